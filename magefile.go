@@ -5,12 +5,15 @@ package main
 import (
 	"archive/tar"
 	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strings"
 	"time"
 
@@ -42,7 +45,28 @@ const (
 	PermissionUserReadWriteExecute = 0o0700
 )
 
+// installTestPlaybook calls the lookup with credentials the DSV stand-in (%s is its URL template) rejects.
+// The play passes only if the plugin was loaded, imported the SDK and surfaced the credentials error.
+const installTestPlaybook = `---
+- hosts: localhost
+  gather_facts: false
+  tasks:
+    - name: Call the lookup plugin
+      ansible.builtin.set_fact:
+        secret: "{{ lookup('delinea.core.dsv', '/smoke/test', tenant='smoke', client_id='id', client_secret='secret', url_template='%s') }}"
+      register: result
+      ignore_errors: true
+
+    - name: Plugin must be loaded and surface the credentials error
+      ansible.builtin.assert:
+        that:
+          - result is failed
+          - "'DSV lookup failure: unable to authenticate' in (result.msg | default(''))"
+        fail_msg: "unexpected result of the lookup: {{ result.msg | default(result) }}"
+`
+
 // ✨ Init unfolds initial environment for productive work.
+// Set `PYTHON` to a Python version (e.g. "3.11") or interpreter path to use instead of `python3` from PATH.
 func Init() error {
 	magetoolsutils.CheckPtermDebug()
 
@@ -91,7 +115,7 @@ func Clean() {
 	pterm.Info.Println("🧹 Clean() completed")
 }
 
-// 🧪 Test runs unit and sanity tests.
+// 🧪 Test runs unit and sanity tests, set `TEST_CHANGED=true` to only test changes (see TestUnit).
 func Test() error {
 	magetoolsutils.CheckPtermDebug()
 	if err := TestUnit(); err != nil {
@@ -100,7 +124,8 @@ func Test() error {
 	return TestSanity()
 }
 
-// 🧪 TestSanity runs sanity tests in containers.
+// 🧪 TestSanity runs sanity tests, in containers when available (see `TEST_MODE`).
+// Set `TEST_CHANGED=true` to only check files changed against `TEST_BASE_REF`.
 func TestSanity() error {
 	magetoolsutils.CheckPtermDebug()
 
@@ -110,19 +135,21 @@ func TestSanity() error {
 		pterm.Error.Println("run `mage init` first")
 		return nil
 	}
-
-	now := time.Now()
-	if err := venvRunV(
-		"ansible-test", "sanity", "--docker", "--color", "yes",
-		"--exclude", "vendor/", "--exclude", ".devcontainer/",
-	); err != nil {
+	target, err := testTargetDefault()
+	if err != nil {
 		return err
 	}
-	pterm.Success.Printfln("sanity tests (took: %s)", time.Since(now))
-	return nil
+	if err := testRunStart(target.Dir); err != nil {
+		return err
+	}
+	now := time.Now()
+	err = testSanity(target)
+	testReport(target, now, err)
+	return err
 }
 
-// 🧪 TestUnit runs unit tests in containers.
+// 🧪 TestUnit runs unit tests, in containers when available (see `TEST_MODE`).
+// Set `TEST_CHANGED=true` to only run the tests affected by changes against `TEST_BASE_REF`.
 func TestUnit() error {
 	magetoolsutils.CheckPtermDebug()
 
@@ -132,43 +159,28 @@ func TestUnit() error {
 		pterm.Error.Println("run `mage init` first")
 		return nil
 	}
-
-	testsOutput := filepath.Join("tests", "output")
-
-	if _, err := os.Stat(testsOutput); err == nil {
-		pterm.DefaultSection.Println("Cleanup old output:")
-		if err := os.RemoveAll(testsOutput); err != nil {
-			pterm.Error.Printfln("🧹 failed to delete %q: %v", testsOutput, err)
-			return nil
-		}
-		pterm.Success.Printfln("🧹 %q", testsOutput)
+	target, err := testTargetDefault()
+	if err != nil {
+		return err
 	}
-
-	pterm.DefaultSection.Println("Unit Tests:")
-
-	now := time.Now()
-	if err := venvRunV(
-		"ansible-test", "units", "--docker", "--color", "yes", "--coverage",
-	); err != nil {
+	if err := testRunStart(target.Dir); err != nil {
 		return err
 	}
-
-	pterm.Success.Printfln("unit tests (took: %s)", time.Since(now))
-
-	pterm.DefaultSection.Println("Code Coverage Report:")
-
-	if err := venvRun(
-		"ansible-test", "coverage", "xml", "-v", "--requirements",
-		"--group-by", "command", "--group-by", "version",
-	); err != nil {
+	now := time.Now()
+	err = testUnits(target)
+	testReport(target, now, err)
+	if err != nil || target.Selection != nil {
+		// Coverage of a subset of the unit tests is not comparable with the thresholds.
 		return err
 	}
-	return venvRunV("ansible-test", "coverage", "report")
+	return coverageGate(target.Dir)
 }
 
 // 🔼 Bump increments version in the galaxy file of the collection, using yq.
 // Valid types are "major", "minor", "patch"
-func Bump(bumpType string) error {
+func Bump(bumpType string) (err error) {
+	defer ledgerAppend("bump", time.Now(), &err)
+
 	pterm.DefaultHeader.Printfln("Version Bump")
 
 	galaxyYaml := "galaxy.yml"
@@ -210,14 +222,15 @@ func Bump(bumpType string) error {
 }
 
 // 📜 Changelog helps with creating a release changelog.
-func Changelog() error {
+func Changelog() (err error) {
 	magetoolsutils.CheckPtermDebug()
+	defer ledgerAppend("changelog", time.Now(), &err)
 
 	pterm.DefaultHeader.Println("antsibull-changelog")
 
 	if !venvExists() {
 		pterm.Error.Println("run `mage init` first")
-		return nil
+		return errors.New("virtual environment is missing")
 	}
 	if !venvBinExists("antsibull-changelog") {
 		if err := venvInstall("antsibull-changelog"); err != nil {
@@ -267,14 +280,15 @@ func Changelog() error {
 }
 
 // 📦 Build packages the collection into a publishable archive.
-func Build() error {
+func Build() (err error) {
 	magetoolsutils.CheckPtermDebug()
+	defer ledgerAppend("build", time.Now(), &err)
 
 	pterm.DefaultHeader.Println("ansible-galaxy collection build")
 
 	if !venvBinExists("ansible-galaxy") {
 		pterm.Error.Println("run `mage init` first")
-		return nil
+		return errors.New("ansible-galaxy is not installed")
 	}
 
 	if err := venvRun(
@@ -297,15 +311,96 @@ func Build() error {
 	return nil
 }
 
+// 🧪 InstallTest installs the built archive into a temporary collections path and checks Ansible can load it.
+func InstallTest() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("ansible-galaxy collection install")
+
+	if !venvBinExists("ansible-galaxy") {
+		pterm.Error.Println("run `mage init` first")
+		return nil
+	}
+
+	path, err := archiveFind("delinea-core*.tar.gz")
+	if err != nil {
+		pterm.Error.Println("run `mage build` first")
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "delinea-core-install-")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			pterm.Error.Printfln("🧹 failed to delete %q: %v", dir, err)
+		}
+	}()
+
+	env := map[string]string{
+		"ANSIBLE_COLLECTIONS_PATH":          dir,
+		"ANSIBLE_COLLECTIONS_SCAN_SYS_PATH": "false",
+	}
+
+	now := time.Now()
+	if err := venvRunWithV(env, "ansible-galaxy", "collection", "install", "--force", "-p", dir, path); err != nil {
+		return fmt.Errorf("installing %q failed: %w", path, err)
+	}
+
+	pterm.DefaultSection.Println("Plugin documentation:")
+	doc, err := venvOutputWith(env, "ansible-doc", "-t", "lookup", "delinea.core.dsv")
+	if err != nil {
+		return fmt.Errorf("rendering docs of delinea.core.dsv failed: %w", err)
+	}
+	if !strings.Contains(strings.ToLower(doc), "delinea.core.dsv") {
+		return fmt.Errorf("ansible-doc did not render docs of delinea.core.dsv")
+	}
+	pterm.Println(doc)
+
+	pterm.DefaultSection.Println("Lookup plugins:")
+	list, err := venvOutputWith(env, "ansible-doc", "-l", "-t", "lookup", "delinea.core")
+	if err != nil {
+		return fmt.Errorf("listing lookup plugins failed: %w", err)
+	}
+	if !strings.Contains(list, "delinea.core.dsv") {
+		return fmt.Errorf("lookup plugin delinea.core.dsv was not found in installed collection")
+	}
+	pterm.Println(list)
+
+	pterm.DefaultSection.Println("Smoke playbook:")
+	if err := integrationInstall(); err != nil {
+		return err
+	}
+	// No credentials are accepted, every token request fails with "unable to authenticate".
+	server := newDSVServer(&dsvFixture{})
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		return err
+	}
+	defer server.Close()
+
+	playbook := filepath.Join(dir, "smoke.yml")
+	if err := writeFile(playbook, fmt.Sprintf(installTestPlaybook, server.URLTemplate())); err != nil {
+		return err
+	}
+	if err := venvRunWithV(env, "ansible-playbook", "-i", "localhost,", "-c", "local", playbook); err != nil {
+		return fmt.Errorf("smoke playbook failed: %w", err)
+	}
+
+	pterm.Success.Printfln("installed and loaded %q (took: %s)", path, time.Since(now))
+	return nil
+}
+
 // 🚀 Publish sends archived collection to Ansible Galaxy.
-func Publish() error {
+func Publish() (err error) {
 	magetoolsutils.CheckPtermDebug()
+	defer ledgerAppend("publish", time.Now(), &err)
 
 	pterm.DefaultHeader.Println("ansible-galaxy collection publish")
 
 	if !venvBinExists("ansible-galaxy") {
 		pterm.Error.Println("run `mage init` first")
-		return nil
+		return errors.New("ansible-galaxy is not installed")
 	}
 
 	gxServer, gxKey := os.Getenv("GALAXY_SERVER"), os.Getenv("GALAXY_KEY")
@@ -324,79 +419,114 @@ func Publish() error {
 		return err
 	}
 
-	pterm.DefaultSection.Printfln("Publishing `%s` to %s", path, gxServer)
+	// Fails before publishing when the release record would not verify.
+	if err := releaseSigningCheck(); err != nil {
+		return err
+	}
+	if err := publish(gxServer, gxKey, path); err != nil {
+		return err
+	}
 
-	now := time.Now()
-	if err := venvRunV(
-		"ansible-galaxy", "collection", "publish", "-v",
-		"--server", gxServer, "--api-key", gxKey, path,
-	); err != nil {
-		return fmt.Errorf("running `ansible-galaxt collection publish` failed")
+	record, err := galaxyVerify(gxServer, gxKey, path)
+	if err != nil {
+		return err
+	}
+	if err := releaseRecordWrite(ArtifactDir, record); err != nil {
+		return err
+	}
+	// The release is live, failing webhooks are reported and recorded on their own.
+	if err := publishNotify(record); err != nil {
+		pterm.Warning.Printfln("published, but the release notification failed: %v", err)
 	}
-	pterm.Success.Printfln("Published collection (took: %s)", time.Since(now))
 	return nil
 }
 
-// 🔍 Doctor will validate the required tools and environment variables are available.
-func Doctor() error {
+// publishNotify sends the release notification, recorded as "notify" in the release ledger when webhooks are set.
+func publishNotify(record *releaseRecord) (err error) {
+	if os.Getenv(ReleaseWebhooksEnv) == "" {
+		return releaseNotify(record)
+	}
+	defer ledgerAppend("notify", time.Now(), &err)
+	return releaseNotify(record)
+}
+
+// 🔎 Verify downloads the published collection from `GALAXY_SERVER` and compares it with the local archive.
+func Verify() error {
 	magetoolsutils.CheckPtermDebug()
 
-	pterm.DefaultHeader.Println("Check Environment")
+	pterm.DefaultHeader.Println("Verify published collection")
 
-	if !venvExists() {
-		pterm.Error.Println("run `mage init` first")
-		return nil
+	gxServer, gxKey := os.Getenv("GALAXY_SERVER"), os.Getenv("GALAXY_KEY")
+	if gxServer == "" {
+		pterm.Error.Printfln("env variable `GALAXY_SERVER` is required, but not set. Skipping verify.")
+		return fmt.Errorf("missing required environment variables")
 	}
 
-	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
-	printer := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary)
+	path, err := archiveFind("delinea-core*.tar.gz")
+	if err != nil {
+		pterm.Error.Println("run `mage build` first")
+		return err
+	}
 
-	tbl := pterm.TableData{
-		[]string{"Status", "Check", "Value", "Notes"},
-		[]string{"✅", "Go version", runtime.Version(), ""},
-		[]string{"✅", "GOOS", runtime.GOOS, ""},
-		[]string{"✅", "GOARCH", runtime.GOARCH, ""},
-		[]string{"✅", "GOROOT", runtime.GOROOT(), ""},
-		[]string{"✅", "GOPATH", os.Getenv("GOPATH"), ""},
+	if err := releaseSigningCheck(); err != nil {
+		return err
 	}
+	record, err := galaxyVerify(gxServer, gxKey, path)
+	if err != nil {
+		return err
+	}
+	return releaseRecordWrite(ArtifactDir, record)
+}
 
-	defer func() {
-		if err := printer.WithData(tbl).Render(); err != nil {
-			pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
-		}
-	}()
+// 🧪 TestPublish publishes the built archive to a local Galaxy stand-in and verifies the result.
+func TestPublish() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Publish to local Galaxy stand-in")
 
-	errorCount := 0
+	if !venvBinExists("ansible-galaxy") {
+		pterm.Error.Println("run `mage init` first")
+		return nil
+	}
 
-	_, tbl, err := checkEnvVar(&checkEnv{Name: "GALAXY_SERVER", IsSecret: false, IsRequired: true, Tbl: tbl, Notes: "required for defining target publish location"})
+	path, err := archiveFind("delinea-core*.tar.gz")
 	if err != nil {
-		errorCount++
+		pterm.Error.Println("run `mage build` first")
+		return err
 	}
-	_, tbl, err = checkEnvVar(&checkEnv{Name: "GALAXY_KEY", IsSecret: true, IsRequired: true, Tbl: tbl, Notes: "required for publishing"})
+
+	dir, err := os.MkdirTemp("", "galaxy-stand-in-")
 	if err != nil {
-		errorCount++
+		return err
 	}
+	defer os.RemoveAll(dir)
 
-	output, err := venvOutput("ansible-galaxy", "--version")
+	server := newGalaxyServer(dir)
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		return err
+	}
+	defer server.Close()
+
+	if err := publish(server.URL, "stand-in-key", path); err != nil {
+		return err
+	}
+
+	record, err := galaxyVerify(server.URL, "stand-in-key", path)
 	if err != nil {
-		errorCount++
-		tbl = append(tbl, []string{"❌", "ansible-galaxy", "ansible-galaxy", "required for building and publishing"})
-	} else {
-		output = strings.Split(output, "\n")[0]
-		tbl = append(tbl, []string{"✅", "ansible-galaxy", output, "required cli tool"})
+		return err
 	}
-	version, err := venvOutput("python3", "--version")
+	if err := releaseRecordWrite(dir, record); err != nil {
+		return err
+	}
+	// The rehearsal trusts the local signing key, releases are verified with releaseTrustedKey.
+	key, err := releaseSigningKey()
 	if err != nil {
-		errorCount++
-		tbl = append(tbl, []string{"❌", "python3", "python3", "required for building and publishing"})
-	} else {
-		tbl = append(tbl, []string{"✅", "python3", version, "required for building and publishing"})
+		return err
 	}
-
-	if errorCount > 0 {
-		pterm.Error.Printfln("required checks failed: %d", errorCount)
-		return fmt.Errorf("failed %d checks", errorCount)
+	if err := releaseRecordCheck(releaseRecordPath(dir, record), key.Public().(ed25519.PublicKey)); err != nil {
+		return err
 	}
+	pterm.Success.Printfln("stand-in publish and verification passed for %q", path)
 	return nil
 }
 
@@ -426,28 +556,188 @@ func ExportPluginDocs() error {
 //          Helper Functions           //
 // ----------------------------------- //
 
+// testTarget describes where ansible-test runs: the virtual environment, the collection root and the output,
+// as well as the execution mode and Python version passed to ansible-test.
+type testTarget struct {
+	Venv   string
+	Dir    string
+	Output io.Writer
+	Mode   string
+	Python string
+
+	// Selection limits the tests to the changes against a base ref, nil runs all tests.
+	Selection *testSelection
+}
+
+// testTargetDefault runs in the checkout, or its link when not stored below `ansible_collections/`,
+// with the default virtual environment, printing to the terminal.
+func testTargetDefault() (testTarget, error) {
+	dir, err := collectionDir()
+	if err != nil {
+		return testTarget{}, err
+	}
+	target := testTarget{Venv: venvPath(), Dir: dir, Output: os.Stdout}
+	if err := target.resolve(); err != nil {
+		return target, err
+	}
+	selection, err := testSelectionDetect()
+	target.Selection = selection
+	return target, err
+}
+
+func testSanity(target testTarget) error {
+	now := time.Now()
+	args := append([]string{"sanity"}, target.modeArgs()...)
+	if target.Selection != nil {
+		if !target.Selection.Sanity {
+			pterm.Info.WithWriter(target.Output).Println("no changes checked by sanity tests, skipping")
+			return nil
+		}
+		args = append(args, target.Selection.changedArgs()...)
+	}
+	source, err := sanityIgnoresRead(target.Dir)
+	if err != nil {
+		return err
+	}
+	args = append(args, "--color", "yes", "--junit")
+	for _, exclude := range source.Exclude {
+		args = append(args, "--exclude", exclude)
+	}
+	if err := target.run(true, "ansible-test", args...); err != nil {
+		return err
+	}
+	pterm.Success.WithWriter(target.Output).Printfln("sanity tests (took: %s)", time.Since(now))
+	return nil
+}
+
+func testUnits(target testTarget) error {
+	section := pterm.DefaultSection.WithWriter(target.Output)
+	testsOutput := filepath.Join(target.Dir, "tests", "output")
+
+	if target.Selection != nil && len(target.Selection.Units) == 0 {
+		pterm.Info.WithWriter(target.Output).Println("no changes affecting unit tests, skipping")
+		return nil
+	}
+
+	if _, err := os.Stat(testsOutput); err == nil {
+		section.Println("Cleanup old output:")
+		if err := os.RemoveAll(testsOutput); err != nil {
+			pterm.Error.WithWriter(target.Output).Printfln("🧹 failed to delete %q: %v", collectionRepoPath(testsOutput), err)
+			return nil
+		}
+		pterm.Success.WithWriter(target.Output).Printfln("🧹 %q", collectionRepoPath(testsOutput))
+	}
+
+	section.Println("Unit Tests:")
+
+	now := time.Now()
+	args := append([]string{"units"}, target.modeArgs()...)
+	args = append(args, "--color", "yes", "--coverage")
+	if target.Selection != nil {
+		args = append(args, target.Selection.Units...)
+	}
+	if err := target.run(true, "ansible-test", args...); err != nil {
+		return err
+	}
+
+	pterm.Success.WithWriter(target.Output).Printfln("unit tests (took: %s)", time.Since(now))
+
+	section.Println("Code Coverage Report:")
+
+	if err := target.run(false,
+		"ansible-test", "coverage", "xml", "-v", "--requirements",
+		"--group-by", "command", "--group-by", "version",
+	); err != nil {
+		return err
+	}
+	return target.run(true, "ansible-test", "coverage", "report")
+}
+
+// run executes a binary of the target's virtual environment inside the target's collection root.
+// Output is only shown when verbose is set or mage runs with `-v`.
+func (t testTarget) run(verbose bool, cmd string, args ...string) error {
+	venv, err := filepath.Abs(t.Venv)
+	if err != nil {
+		return err
+	}
+	runnable, env := venvCommandIn(venv, nil, cmd)
+	if t.Mode == TestModePodman {
+		env["ANSIBLE_TEST_PREFER_PODMAN"] = "1"
+	}
+	if cmd == "ansible-test" {
+		// The working directory of a process has symlinks resolved, ansible-test would not find
+		// `ansible_collections/` when running from the link of collectionLayout.
+		dir, err := filepath.Abs(t.Dir)
+		if err != nil {
+			return err
+		}
+		env["ANSIBLE_TEST_CONTENT_ROOT"] = dir
+	}
+	return t.runWith(verbose, env, runnable, args...)
+}
+
+// runWith executes the command inside the target's collection root with the environment variables added.
+func (t testTarget) runWith(verbose bool, env map[string]string, cmd string, args ...string) error {
+	pterm.Debug.Printfln("runnable: %s (in %s)", cmd, t.Dir)
+
+	command := exec.Command(cmd, args...)
+	command.Dir = t.Dir
+	command.Env = os.Environ()
+	for key, value := range env {
+		command.Env = append(command.Env, key+"="+value)
+	}
+	if verbose || mg.Verbose() {
+		command.Stdout = t.Output
+		command.Stderr = t.Output
+		if t.Output == os.Stdout {
+			command.Stderr = os.Stderr
+		}
+	}
+	if err := command.Run(); err != nil {
+		return fmt.Errorf("running %s %s failed: %w", cmd, strings.Join(args, " "), err)
+	}
+	return nil
+}
+
 func ansibleInit(version string) error {
 	magetoolsutils.CheckPtermDebug()
 
-	pterm.DefaultHeader.Printfln("Ansible %s Init()", AnsibleLatest)
+	pterm.DefaultHeader.Printfln("Ansible %s Init()", version)
 
-	link := fmt.Sprintf("https://github.com/ansible/ansible/archive/%s.tar.gz", version)
+	python, err := pythonSelect(os.Getenv("PYTHON"), version)
+	if err != nil {
+		return err
+	}
+	pterm.Info.Printfln("python %s: %s (%s)", python.Version, python.Path, python.Source)
 
-	mg.SerialDeps(
-		venvInit,
-		func() error { return venvInstall("wheel") },
-		func() error { return venvInstall(link) },
-	)
+	link := fmt.Sprintf("https://github.com/ansible/ansible/archive/%s.tar.gz", version)
+	venv := venvKeyed(version, python)
+
+	// Returns errors instead of exiting like mg.SerialDeps, `mage doctor` continues with other fixes.
+	for _, step := range []func() error{
+		func() error { return venvCreate(venv, python.Path, true) },
+		func() error { return venvInstallIn(venv, "wheel") },
+		func() error { return venvInstallIn(venv, link) },
+		func() error { return venvLink(venv) },
+	} {
+		if err := step(); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func venvInit() error {
-	if err := mkdir(CacheDir); err != nil {
+// venvCreate creates a virtual environment at path with the Python interpreter, wiping an existing one if clear is set.
+func venvCreate(path, python string, clear bool) error {
+	if err := mkdir(filepath.Dir(path)); err != nil {
 		return err
 	}
 
-	path := filepath.Join(CacheDir, "venv")
-	err := sh.Run("python3", "-m", "venv", "--clear", path)
+	args := []string{"-m", "venv", path}
+	if clear {
+		args = append(args, "--clear")
+	}
+	err := sh.Run(python, args...)
 	if err != nil {
 		pterm.Error.Printfln("error creating a new virtual environment: %s", err)
 		return err
@@ -457,16 +747,49 @@ func venvInit() error {
 	return nil
 }
 
+// venvPath is the virtual environment used by all targets unless stated otherwise.
+// It links to the one of the Ansible and Python version last selected by `mage init`.
+func venvPath() string { return filepath.Join(CacheDir, "venv") }
+
+// venvLink points venvPath to the virtual environment, replacing the link or a virtual environment
+// created before they were kept per Ansible and Python version.
+func venvLink(venv string) error {
+	path := venvPath()
+	if info, err := os.Lstat(path); err == nil {
+		remove := os.Remove
+		if info.Mode()&os.ModeSymlink == 0 {
+			remove = os.RemoveAll
+		}
+		if err := remove(path); err != nil {
+			return err
+		}
+	}
+	target, err := filepath.Rel(filepath.Dir(path), venv)
+	if err != nil {
+		return err
+	}
+	if err := os.Symlink(target, path); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("%s now uses %s", path, venv)
+	return nil
+}
+
 func venvExists() bool { return venvBinExists("pip3") }
 
-func venvBinExists(name string) bool {
-	_, err := os.Stat(filepath.Join(CacheDir, "venv", "bin", name))
+func venvBinExists(name string) bool { return venvBinExistsIn(venvPath(), name) }
+
+func venvBinExistsIn(venv, name string) bool {
+	_, err := os.Stat(filepath.Join(venv, "bin", name))
 	return err == nil
 }
 
-func venvInstall(name string) error {
+func venvInstall(name string) error { return venvInstallIn(venvPath(), name) }
+
+func venvInstallIn(venv, name string) error {
 	now := time.Now()
-	if err := venvRun("pip3", "install", name, "--disable-pip-version-check"); err != nil {
+	runnable, env := venvCommandIn(venv, nil, "pip3")
+	if err := sh.RunWith(env, runnable, "install", name, "--disable-pip-version-check"); err != nil {
 		pterm.Error.Printfln("error installing name %q: %s", name, err)
 		return err
 	}
@@ -474,19 +797,18 @@ func venvInstall(name string) error {
 	return nil
 }
 
-func venvRun(cmd string, args ...string) error  { return venvRunBinary(false, cmd, args...) }
-func venvRunV(cmd string, args ...string) error { return venvRunBinary(true, cmd, args...) }
+func venvRun(cmd string, args ...string) error  { return venvRunBinary(false, nil, cmd, args...) }
+func venvRunV(cmd string, args ...string) error { return venvRunBinary(true, nil, cmd, args...) }
+
+// venvRunWithV runs the command with additional environment variables set.
+func venvRunWithV(extra map[string]string, cmd string, args ...string) error {
+	return venvRunBinary(true, extra, cmd, args...)
+}
 
-func venvRunBinary(useStdout bool, cmd string, args ...string) error {
+func venvRunBinary(useStdout bool, extra map[string]string, cmd string, args ...string) error {
 	magetoolsutils.CheckPtermDebug()
-	path := filepath.Join(CacheDir, "venv")
-	venvBin := filepath.Join(path, "bin")
-	runnable := filepath.Join(venvBin, cmd)
+	runnable, env := venvCommand(extra, cmd)
 	pterm.Debug.Printfln("runnable: %s", runnable)
-	env := map[string]string{
-		"PATH":        venvBin + ":" + os.Getenv("PATH"),
-		"VIRTUAL_ENV": path,
-	}
 
 	if useStdout {
 		return sh.RunWithV(env, runnable, args...)
@@ -495,14 +817,30 @@ func venvRunBinary(useStdout bool, cmd string, args ...string) error {
 }
 
 func venvOutput(cmd string, args ...string) (string, error) {
-	path := filepath.Join(CacheDir, "venv")
-	venvBin := filepath.Join(path, "bin")
-	runnable := filepath.Join(venvBin, cmd)
+	return venvOutputWith(nil, cmd, args...)
+}
+
+// venvOutputWith returns stdout of the command run with additional environment variables set.
+func venvOutputWith(extra map[string]string, cmd string, args ...string) (string, error) {
+	runnable, env := venvCommand(extra, cmd)
+	return sh.OutputWith(env, runnable, args...)
+}
+
+// venvCommand returns the path to the binary inside the virtual environment and the environment to run it with.
+func venvCommand(extra map[string]string, cmd string) (string, map[string]string) {
+	return venvCommandIn(venvPath(), extra, cmd)
+}
+
+func venvCommandIn(venv string, extra map[string]string, cmd string) (string, map[string]string) {
+	venvBin := filepath.Join(venv, "bin")
 	env := map[string]string{
 		"PATH":        venvBin + ":" + os.Getenv("PATH"),
-		"VIRTUAL_ENV": path,
+		"VIRTUAL_ENV": venv,
 	}
-	return sh.OutputWith(env, runnable, args...)
+	for key, value := range extra {
+		env[key] = value
+	}
+	return filepath.Join(venvBin, cmd), env
 }
 
 func writeFile(path string, data string) error {
@@ -534,6 +872,20 @@ func archiveFind(pattern string) (string, error) {
 	}
 }
 
+func publish(server, key, path string) error {
+	pterm.DefaultSection.Printfln("Publishing `%s` to %s", path, server)
+
+	now := time.Now()
+	if err := venvRunV(
+		"ansible-galaxy", "collection", "publish", "-v",
+		"--server", server, "--api-key", key, path,
+	); err != nil {
+		return fmt.Errorf("running `ansible-galaxy collection publish` failed")
+	}
+	pterm.Success.Printfln("Published collection (took: %s)", time.Since(now))
+	return nil
+}
+
 func archiveContent(path string) ([]string, error) {
 	if _, err := os.Stat(path); err != nil {
 		return nil, err
@@ -567,38 +919,47 @@ func archiveContent(path string) ([]string, error) {
 	return files, nil
 }
 
-type checkEnv struct {
-	Name       string
-	IsSecret   bool
-	IsRequired bool
-	Tbl        pterm.TableData
-	Notes      string
-}
+// archiveReadFile returns the content of a single file stored in the archive.
+func archiveReadFile(path, name string) ([]byte, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
 
-// checkEnvVar performs a check on environment variable and helps build a report summary of the failing conditions, missing variables, and bypasses logging if it's a secret.
-// Yes this could be replaced by the `env` package but I had this in place and the output is nice for debugging so I left it. - Sheldon 😀
-//
-//nolint:unparam // ignoring as i'll want to use the values in the future, ok to leave for now.
-func checkEnvVar(ckv *checkEnv) (string, pterm.TableData, error) {
-	value, ok := os.LookupEnv(ckv.Name)
-	switch {
-	case ok && ckv.IsSecret:
-		ckv.Tbl = append(ckv.Tbl, []string{"✅", ckv.Name, "***** secret set, but not logged *****", ckv.Notes})
-		return value, ckv.Tbl, nil
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gzipReader.Close()
 
-	case ok && !ckv.IsSecret:
-		ckv.Tbl = append(ckv.Tbl, []string{"✅", ckv.Name, value, ckv.Notes})
-		return value, ckv.Tbl, nil
+	tarReader := tar.NewReader(gzipReader)
 
-	case !ok && ckv.IsRequired:
-		ckv.Tbl = append(ckv.Tbl, []string{"❌", ckv.Name, "", ckv.Notes})
-		return "", ckv.Tbl, fmt.Errorf("%s is required and not set", ckv.Name)
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.TrimPrefix(header.Name, "./") == name {
+			return io.ReadAll(tarReader)
+		}
+	}
+	return nil, fmt.Errorf("file %q not found in %q", name, path)
+}
 
-	case !ok && !ckv.IsRequired:
-		ckv.Tbl = append(ckv.Tbl, []string{"👉", ckv.Name, "", ckv.Notes})
-		return "", ckv.Tbl, nil
+func fileSHA256(path string) (string, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
 
-	default:
-		return "", nil, nil // Unreachable.
+	hash := sha256.New()
+	if _, err := io.Copy(hash, r); err != nil {
+		return "", err
 	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
 }
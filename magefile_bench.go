@@ -0,0 +1,395 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// BenchLookups is the number of lookups per scenario, override with `BENCH_LOOKUPS`.
+	BenchLookups = 50
+
+	// BenchTerms is the number of terms per lookup of the "many terms" scenario, override with `BENCH_TERMS`.
+	BenchTerms = 10
+
+	// BenchLatency is the delay of every response of the DSV stand-in, override with `BENCH_LATENCY`.
+	BenchLatency = 20 * time.Millisecond
+
+	// BenchTolerance is how many percent slower than the baseline a scenario may get, override with `BENCH_TOLERANCE`.
+	BenchTolerance = 25.0
+
+	// BenchResultFile is where `mage bench` writes its results.
+	BenchResultFile = ArtifactDir + "/bench.json"
+
+	// BenchBaselineFile keeps the results of the last accepted run, update it with `mage benchBaseline`.
+	BenchBaselineFile = "tests/bench-baseline.json"
+)
+
+// benchScenario is a playbook run making the same lookup several times.
+type benchScenario struct {
+	Name    string
+	DataKey string
+	Terms   int
+}
+
+// benchSettings are the parameters of a run, results are only compared with a baseline of the same settings.
+type benchSettings struct {
+	Lookups int    `json:"lookups"`
+	Terms   int    `json:"terms"`
+	Latency string `json:"latency"`
+}
+
+// benchReport is the content of the result and baseline files.
+type benchReport struct {
+	Settings  benchSettings `json:"settings"`
+	Scenarios []benchResult `json:"scenarios"`
+}
+
+// benchResult are the measurements of one scenario, durations in milliseconds.
+//
+//nolint:tagliatelle // Keys use snake_case with the unit as suffix, e.g. wall_ms.
+type benchResult struct {
+	Name           string  `json:"name"`
+	Lookups        int     `json:"lookups"`
+	Wall           float64 `json:"wall_ms"`
+	TokenRequests  int     `json:"token_requests"`
+	SecretRequests int     `json:"secret_requests"`
+	P50            float64 `json:"p50_ms"`
+	P90            float64 `json:"p90_ms"`
+	P99            float64 `json:"p99_ms"`
+}
+
+// ⏱️ Bench runs playbooks making many lookups against the DSV stand-in and compares wall time, requests and
+// lookup latencies with the baseline. Set `BENCH_LOOKUPS`, `BENCH_TERMS` and `BENCH_LATENCY` to change the load.
+func Bench() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Lookup Benchmark")
+
+	report, err := benchRun()
+	if err != nil {
+		return err
+	}
+	if err := benchWrite(BenchResultFile, report); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("results written to %q", BenchResultFile)
+
+	baseline, err := benchRead(BenchBaselineFile)
+	if err != nil {
+		return err
+	}
+	return benchCompare(report, baseline)
+}
+
+// 📌 BenchBaseline stores the results of the last `mage bench` run as baseline.
+func BenchBaseline() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Benchmark Baseline")
+
+	report, err := benchRead(BenchResultFile)
+	if err != nil {
+		return err
+	}
+	if report == nil {
+		return fmt.Errorf("no results in %q, run `mage bench` first", BenchResultFile)
+	}
+	if err := benchWrite(BenchBaselineFile, report); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("stored %d scenarios in %q", len(report.Scenarios), BenchBaselineFile)
+	return nil
+}
+
+// benchRun starts the DSV stand-in with the configured latency and runs every scenario against it.
+func benchRun() (*benchReport, error) {
+	lookups, err := benchSetting("BENCH_LOOKUPS", BenchLookups)
+	if err != nil {
+		return nil, err
+	}
+	terms, err := benchSetting("BENCH_TERMS", BenchTerms)
+	if err != nil {
+		return nil, err
+	}
+	latency := BenchLatency
+	if value := os.Getenv("BENCH_LATENCY"); value != "" {
+		latency, err = time.ParseDuration(value)
+		if err != nil || latency < 0 {
+			return nil, fmt.Errorf("BENCH_LATENCY must be a duration like 20ms, got %q", value)
+		}
+	}
+
+	if !venvBinExists("ansible-playbook") {
+		return nil, fmt.Errorf("run `mage init` first")
+	}
+	if err := integrationInstall(); err != nil {
+		return nil, err
+	}
+
+	fixture, err := dsvFixtureReadDir(filepath.Join(E2EDir, "fixtures"))
+	if err != nil {
+		return nil, err
+	}
+	root, _, err := collectionLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	// Faults of the fixture would make the lookups fail, only the latency is simulated.
+	fixture.Faults = nil
+	server := newDSVServer(fixture)
+	if latency > 0 {
+		server.SetFaults([]dsvFault{{Delay: latency.String()}})
+	}
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	defer server.Close()
+
+	dir, err := os.MkdirTemp("", "delinea-core-bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	env := map[string]string{
+		"ANSIBLE_COLLECTIONS_PATH": root,
+		"ANSIBLE_NOCOLOR":          "1",
+		"DSV_TENANT":               "mock",
+		"DSV_CLIENT_ID":            fixture.Credentials.ClientID,
+		"DSV_CLIENT_SECRET":        fixture.Credentials.ClientSecret,
+		"DSV_URL_TEMPLATE":         server.URLTemplate(),
+	}
+
+	report := &benchReport{Settings: benchSettings{Lookups: lookups, Terms: terms, Latency: latency.String()}}
+	pterm.Info.Printfln("%d lookups per scenario, %d terms in the many terms scenario, %s latency",
+		lookups, terms, latency)
+
+	for _, scenario := range []benchScenario{
+		{Name: "single term", Terms: 1},
+		{Name: "data_key", DataKey: "password", Terms: 1},
+		{Name: "many terms", Terms: terms},
+	} {
+		server.Requests()
+		result, err := benchScenarioRun(scenario, lookups, env, filepath.Join(dir, "result.json"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", scenario.Name, err)
+		}
+		requests := server.Requests()
+		result.TokenRequests, result.SecretRequests = requests["token"], requests["secrets"]
+		pterm.Success.Printfln("%s (took: %.0fms)", scenario.Name, result.Wall)
+		report.Scenarios = append(report.Scenarios, result)
+	}
+	return report, nil
+}
+
+// benchScenarioRun runs the benchmark playbook for the scenario and computes the lookup latencies.
+func benchScenarioRun(scenario benchScenario, lookups int, baseEnv map[string]string, resultFile string) (benchResult, error) {
+	env := map[string]string{}
+	for key, value := range baseEnv {
+		env[key] = value
+	}
+	if scenario.DataKey != "" {
+		env["DSV_DATA_KEY"] = scenario.DataKey
+	}
+
+	terms := make([]string, scenario.Terms)
+	for i := range terms {
+		terms[i] = "/test/secret"
+	}
+	extraVars, err := json.Marshal(map[string]interface{}{"count": lookups, "terms": terms, "result_file": resultFile})
+	if err != nil {
+		return benchResult{}, err
+	}
+
+	output := &bytes.Buffer{}
+	venv, err := filepath.Abs(venvPath())
+	if err != nil {
+		return benchResult{}, err
+	}
+	command, env := venvCommandIn(venv, env, "ansible-playbook")
+	target := testTarget{Venv: venv, Dir: ".", Output: output}
+
+	now := time.Now()
+	runErr := target.runWith(true, env, command,
+		"-i", "localhost,", "-c", "local", filepath.Join(E2EDir, "bench.yml"), "-e", string(extraVars),
+	)
+	wall := time.Since(now)
+	pterm.Debug.Printfln("%s:\n%s", scenario.Name, output)
+	if runErr != nil {
+		return benchResult{}, fmt.Errorf("%w:\n%s", runErr, integrationTail(output.String()))
+	}
+
+	data, err := os.ReadFile(resultFile)
+	if err != nil {
+		return benchResult{}, err
+	}
+	timings := []struct {
+		Start   float64 `json:"start"`
+		End     float64 `json:"end"`
+		Results int     `json:"results"`
+	}{}
+	if err := json.Unmarshal(data, &timings); err != nil {
+		return benchResult{}, fmt.Errorf("parsing timings: %w", err)
+	}
+	latencies := make([]float64, 0, len(timings))
+	for _, timing := range timings {
+		if timing.Results != scenario.Terms {
+			return benchResult{}, fmt.Errorf("expected %d results per lookup, got %d", scenario.Terms, timing.Results)
+		}
+		latencies = append(latencies, (timing.End-timing.Start)*1000)
+	}
+	sort.Float64s(latencies)
+
+	return benchResult{
+		Name:    scenario.Name,
+		Lookups: len(timings),
+		Wall:    benchRound(float64(wall) / float64(time.Millisecond)),
+		P50:     benchPercentile(latencies, 50),
+		P90:     benchPercentile(latencies, 90),
+		P99:     benchPercentile(latencies, 99),
+	}, nil
+}
+
+// benchCompare prints the results next to the baseline and fails if a scenario got slower than the tolerance
+// allows or makes more requests.
+func benchCompare(report, baseline *benchReport) error {
+	tolerance := BenchTolerance
+	if value := os.Getenv("BENCH_TOLERANCE"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 {
+			return fmt.Errorf("BENCH_TOLERANCE must be a positive percentage, got %q", value)
+		}
+		tolerance = parsed
+	}
+
+	switch {
+	case baseline == nil:
+		pterm.Info.Printfln("no baseline in %q, store one with `mage benchBaseline`", BenchBaselineFile)
+	case baseline.Settings != report.Settings:
+		pterm.Warning.Printfln("baseline in %q was measured with %+v, not %+v, not comparing",
+			BenchBaselineFile, baseline.Settings, report.Settings)
+		baseline = nil
+	}
+	previous := map[string]benchResult{}
+	if baseline != nil {
+		for _, result := range baseline.Scenarios {
+			previous[result.Name] = result
+		}
+	}
+
+	regressions := []string{}
+	compare := func(name, metric string, value, before float64) string {
+		if before == 0 {
+			return fmt.Sprintf("%.1f", value)
+		}
+		diff := (value - before) * 100 / before
+		if diff > tolerance {
+			regressions = append(regressions, fmt.Sprintf("%s: %s %.1f > %.1f (+%.0f%%)", name, metric, value, before, diff))
+			return fmt.Sprintf("%.1f 📉 +%.0f%%", value, diff)
+		}
+		return fmt.Sprintf("%.1f (%+.0f%%)", value, diff)
+	}
+
+	tbl := pterm.TableData{[]string{"Scenario", "Lookups", "Wall (ms)", "Token requests", "Secret requests", "p50 (ms)", "p90 (ms)", "p99 (ms)"}}
+	for _, result := range report.Scenarios {
+		before, ok := previous[result.Name]
+		tokens := strconv.Itoa(result.TokenRequests)
+		if ok && result.TokenRequests > before.TokenRequests {
+			regressions = append(regressions, fmt.Sprintf("%s: %d token requests > %d", result.Name, result.TokenRequests, before.TokenRequests))
+			tokens += fmt.Sprintf(" 📉 was %d", before.TokenRequests)
+		}
+		secrets := strconv.Itoa(result.SecretRequests)
+		if ok && result.SecretRequests > before.SecretRequests {
+			regressions = append(regressions, fmt.Sprintf("%s: %d secret requests > %d", result.Name, result.SecretRequests, before.SecretRequests))
+			secrets += fmt.Sprintf(" 📉 was %d", before.SecretRequests)
+		}
+		tbl = append(tbl, []string{
+			result.Name, strconv.Itoa(result.Lookups),
+			compare(result.Name, "wall time", result.Wall, before.Wall), tokens, secrets,
+			compare(result.Name, "p50", result.P50, before.P50),
+			compare(result.Name, "p90", result.P90, before.P90),
+			compare(result.Name, "p99", result.P99, before.P99),
+		})
+	}
+
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+
+	if len(regressions) > 0 {
+		return fmt.Errorf("slower than the baseline (tolerance: %.0f%%):\n\t%s", tolerance, strings.Join(regressions, "\n\t"))
+	}
+	return nil
+}
+
+func benchRead(path string) (*benchReport, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	report := &benchReport{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", path, err)
+	}
+	return report, nil
+}
+
+func benchWrite(path string, report *benchReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	const permBits = 0o644
+	return os.WriteFile(path, append(data, '\n'), permBits)
+}
+
+func benchSetting(env string, fallback int) (int, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive number, got %q", env, value)
+	}
+	return n, nil
+}
+
+// benchPercentile returns the nearest-rank percentile of the sorted values.
+func benchPercentile(sorted []float64, percentile float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return benchRound(sorted[rank-1])
+}
+
+func benchRound(ms float64) float64 {
+	return math.Round(ms*10) / 10
+}
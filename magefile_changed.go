@@ -0,0 +1,196 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+)
+
+const (
+	// TestBaseRef is the git ref changes are compared with when `TEST_CHANGED=true`, override with `TEST_BASE_REF`.
+	TestBaseRef = "origin/main"
+)
+
+// testSelection is the subset of tests affected by the changes since the base ref.
+type testSelection struct {
+	Base string
+
+	// Units are the unit test files to run, none skips the unit tests.
+	Units []string
+
+	// Sanity is set if any file checked by sanity tests changed.
+	Sanity bool
+}
+
+// changedArgs returns the arguments limiting ansible-test to the same changes as testChangedFiles.
+func (s *testSelection) changedArgs() []string {
+	return []string{"--changed", "--base-branch", s.Base, "--untracked"}
+}
+
+// testSelectionRun keeps the selection of the run, so e.g. `mage test` detects and prints it once.
+var testSelectionRun struct {
+	sync.Once
+	selection *testSelection
+	err       error
+}
+
+// testSelectionDetect returns the tests affected by the changes against the base ref if `TEST_CHANGED=true`,
+// otherwise nil to run all tests. On the first call of the run it prints the selected tests and the reason
+// for each changed file.
+func testSelectionDetect() (*testSelection, error) {
+	testSelectionRun.Do(func() {
+		testSelectionRun.selection, testSelectionRun.err = testSelectionCompute()
+	})
+	return testSelectionRun.selection, testSelectionRun.err
+}
+
+func testSelectionCompute() (*testSelection, error) {
+	if os.Getenv("TEST_CHANGED") != "true" {
+		return nil, nil
+	}
+	base := os.Getenv("TEST_BASE_REF")
+	if base == "" {
+		base = TestBaseRef
+	}
+
+	changed, err := testChangedFiles(base)
+	if err != nil {
+		return nil, err
+	}
+	allUnits, err := testUnitFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	selection := &testSelection{Base: base}
+	units := map[string]bool{}
+	tbl := pterm.TableData{[]string{"Changed", "Unit tests", "Sanity", "Reason"}}
+	for _, file := range changed {
+		selected, sanity, reason := testSelect(file, allUnits)
+		for _, unit := range selected {
+			units[unit] = true
+		}
+		selection.Sanity = selection.Sanity || sanity
+
+		unitColumn := strings.Join(selected, "\n")
+		if len(selected) == len(allUnits) && len(selected) > 1 {
+			unitColumn = "all"
+		}
+		sanityColumn := ""
+		if sanity {
+			sanityColumn = "✅"
+		}
+		tbl = append(tbl, []string{file, unitColumn, sanityColumn, reason})
+	}
+	for unit := range units {
+		selection.Units = append(selection.Units, unit)
+	}
+	sort.Strings(selection.Units)
+
+	pterm.Info.Printfln("testing changes against %q (TEST_CHANGED=true)", base)
+	if len(changed) == 0 {
+		pterm.Info.Println("no changed files")
+		return selection, nil
+	}
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+	return selection, nil
+}
+
+// testSelect maps a changed file to the affected unit test files and whether sanity tests are needed.
+func testSelect(file string, allUnits []string) ([]string, bool, string) {
+	dir, name := path.Split(file)
+	switch {
+	case strings.HasPrefix(file, "docs/") || strings.HasPrefix(file, "changelogs/") ||
+		path.Ext(file) == ".md" || path.Ext(file) == ".rst":
+		return nil, false, "documentation or changelog"
+
+	case strings.HasPrefix(file, "plugins/module_utils/"):
+		return allUnits, true, "shared plugin code"
+
+	case strings.HasPrefix(file, "plugins/") && path.Ext(file) == ".py":
+		unit := path.Join("tests", "unit", dir, "test_"+name)
+		for _, candidate := range allUnits {
+			if candidate == unit {
+				return []string{unit}, true, "plugin"
+			}
+		}
+		return nil, true, "plugin without unit tests"
+
+	case strings.HasPrefix(file, "tests/unit/") && strings.HasPrefix(name, "test_") && path.Ext(file) == ".py":
+		return []string{file}, true, "unit test"
+
+	case strings.HasPrefix(file, "tests/unit/"):
+		return allUnits, true, "unit test support file"
+
+	case strings.HasPrefix(file, "tests/sanity/"):
+		return nil, true, "sanity test configuration"
+
+	case strings.HasPrefix(file, "tests/integration/"):
+		return nil, true, "integration target"
+
+	case strings.HasPrefix(file, "meta/") || file == "galaxy.yml":
+		return allUnits, true, "collection metadata"
+
+	case strings.HasPrefix(file, "magefile") || strings.HasPrefix(file, ".") || strings.HasPrefix(file, "tests/e2e/") ||
+		file == "go.mod" || file == "go.sum":
+		return nil, false, "development tooling, not tested by ansible-test"
+
+	default:
+		return nil, true, "collection file"
+	}
+}
+
+// testChangedFiles lists the files changed since the merge base with base, including uncommitted and untracked ones,
+// like ansible-test with changedArgs.
+func testChangedFiles(base string) ([]string, error) {
+	mergeBase, err := sh.Output("git", "merge-base", base, "HEAD")
+	if err != nil {
+		return nil, fmt.Errorf("finding merge base with %q (set TEST_BASE_REF to an existing ref): %w", base, err)
+	}
+	diff, err := sh.Output("git", "diff", "--name-only", mergeBase)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := sh.Output("git", "ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	files := []string{}
+	for _, file := range strings.Split(diff+"\n"+untracked, "\n") {
+		if file == "" || seen[file] || strings.HasPrefix(file, CacheDir+"/") || strings.HasPrefix(file, ArtifactDir+"/") {
+			continue
+		}
+		seen[file] = true
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// testUnitFiles lists the unit test files known to git.
+func testUnitFiles() ([]string, error) {
+	output, err := sh.Output("git", "ls-files", "--cached", "--others", "--exclude-standard", "tests/unit")
+	if err != nil {
+		return nil, err
+	}
+	units := []string{}
+	for _, file := range strings.Split(output, "\n") {
+		if strings.HasPrefix(path.Base(file), "test_") && path.Ext(file) == ".py" {
+			units = append(units, file)
+		}
+	}
+	return units, nil
+}
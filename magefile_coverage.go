@@ -0,0 +1,338 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// CoverageMinTotal is the minimum line coverage of `plugins/` in percent, override with `COVERAGE_MIN_TOTAL`.
+	CoverageMinTotal = 80.0
+
+	// CoverageMinFile is the minimum line coverage of every file in `plugins/`, override with `COVERAGE_MIN_FILE`.
+	CoverageMinFile = 70.0
+
+	// CoverageBaselineFile keeps the coverage of the last accepted run, update it with `mage testCoverageBaseline`.
+	CoverageBaselineFile = "tests/coverage-baseline.json"
+
+	// CoverageDetailFiles lists the files whose uncovered lines are always shown.
+	CoverageDetailFiles = "plugins/lookup/dsv.py"
+
+	// coverageTolerance ignores baseline differences caused by rounding.
+	coverageTolerance = 0.05
+)
+
+// coberturaReport is the part of a Cobertura XML report needed for line coverage.
+type coberturaReport struct {
+	Sources  []string `xml:"sources>source"`
+	Packages []struct {
+		Classes []struct {
+			Filename string `xml:"filename,attr"`
+			Lines    []struct {
+				Number int `xml:"number,attr"`
+				Hits   int `xml:"hits,attr"`
+			} `xml:"lines>line"`
+		} `xml:"classes>class"`
+	} `xml:"packages>package"`
+}
+
+// coverageBaseline is the content of the baseline file, coverage in percent.
+type coverageBaseline struct {
+	Total float64            `json:"total"`
+	Files map[string]float64 `json:"files"`
+}
+
+// coverageFile is the line coverage of a single file.
+type coverageFile struct {
+	Lines     int
+	Covered   int
+	Uncovered []int
+}
+
+func (c coverageFile) Percent() float64 {
+	if c.Lines == 0 {
+		return 100
+	}
+	return float64(c.Covered) * 100 / float64(c.Lines)
+}
+
+// 🛡️ TestCoverage fails if the line coverage of `plugins/` in the reports of the last unit test run is below
+// the thresholds, and compares it with the baseline.
+func TestCoverage() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Coverage Gate")
+	return coverageGate(".")
+}
+
+// 📌 TestCoverageBaseline stores the coverage of the last unit test run as baseline.
+func TestCoverageBaseline() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Coverage Baseline")
+
+	files, err := coverageRead(".")
+	if err != nil {
+		return err
+	}
+	return coverageBaselineWrite(files)
+}
+
+// coverageBaselineWrite stores the coverage of the files as baseline.
+func coverageBaselineWrite(files map[string]coverageFile) error {
+	baseline := coverageBaseline{Total: coverageRound(coverageTotal(files).Percent()), Files: map[string]float64{}}
+	for name, file := range files {
+		baseline.Files[name] = coverageRound(file.Percent())
+	}
+
+	data, err := json.MarshalIndent(baseline, "", "  ")
+	if err != nil {
+		return err
+	}
+	const permBits = 0o644
+	if err := os.WriteFile(CoverageBaselineFile, append(data, '\n'), permBits); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("stored %.1f%% total coverage in %q", baseline.Total, CoverageBaselineFile)
+	return nil
+}
+
+// coverageGate checks the coverage reports below dir against the thresholds and the baseline.
+func coverageGate(dir string) error {
+	minTotal, err := coverageThreshold("COVERAGE_MIN_TOTAL", CoverageMinTotal)
+	if err != nil {
+		return err
+	}
+	minFile, err := coverageThreshold("COVERAGE_MIN_FILE", CoverageMinFile)
+	if err != nil {
+		return err
+	}
+
+	files, err := coverageRead(dir)
+	if err != nil {
+		return err
+	}
+	baseline, err := coverageBaselineRead()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	failures := []string{}
+	tbl := pterm.TableData{[]string{"Status", "File", "Lines", "Covered", "Coverage", "Baseline"}}
+	for _, name := range names {
+		file := files[name]
+		status := "✅"
+		if file.Percent() < minFile {
+			status = "❌"
+			failures = append(failures, fmt.Sprintf("%s: %.1f%% < %.1f%%", name, file.Percent(), minFile))
+		}
+		tbl = append(tbl, []string{
+			status, name, strconv.Itoa(file.Lines), strconv.Itoa(file.Covered),
+			fmt.Sprintf("%.1f%%", file.Percent()), coverageCompare(file.Percent(), baseline, name),
+		})
+	}
+
+	total := coverageTotal(files)
+	status := "✅"
+	if total.Percent() < minTotal {
+		status = "❌"
+		failures = append(failures, fmt.Sprintf("total: %.1f%% < %.1f%%", total.Percent(), minTotal))
+	}
+	tbl = append(tbl, []string{
+		status, "total", strconv.Itoa(total.Lines), strconv.Itoa(total.Covered),
+		fmt.Sprintf("%.1f%%", total.Percent()), coverageCompare(total.Percent(), baseline, ""),
+	})
+
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+
+	for _, name := range strings.Fields(CoverageDetailFiles) {
+		if file, ok := files[name]; ok && len(file.Uncovered) > 0 {
+			pterm.Info.Printfln("uncovered lines of %s: %s", name, coverageRanges(file.Uncovered))
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("coverage below threshold:\n\t%s", strings.Join(failures, "\n\t"))
+	}
+	if baseline == nil {
+		pterm.Info.Printfln("no baseline in %q, store one with `mage testCoverageBaseline`", CoverageBaselineFile)
+	}
+	pterm.Success.Printfln("coverage %.1f%% (minimum: %.1f%% total, %.1f%% per file)", total.Percent(), minTotal, minFile)
+	return nil
+}
+
+// coverageRead merges the line coverage of `plugins/` from the Cobertura reports below dir.
+// A line is covered if any report covers it, e.g. for another Python version.
+func coverageRead(dir string) (map[string]coverageFile, error) {
+	reports, err := filepath.Glob(filepath.Join(dir, "tests", "output", "reports", "coverage*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	if len(reports) == 0 {
+		return nil, fmt.Errorf("no coverage reports found in %q, run `mage testUnit` first",
+			collectionRepoPath(filepath.Join(dir, "tests", "output", "reports")))
+	}
+
+	hits := map[string]map[int]bool{}
+	for _, path := range reports {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		report := coberturaReport{}
+		if err := xml.Unmarshal(data, &report); err != nil {
+			return nil, fmt.Errorf("parsing %q: %w", path, err)
+		}
+
+		for _, pkg := range report.Packages {
+			for _, class := range pkg.Classes {
+				name := coveragePluginPath(class.Filename, report.Sources)
+				if name == "" {
+					continue
+				}
+				if hits[name] == nil {
+					hits[name] = map[int]bool{}
+				}
+				for _, line := range class.Lines {
+					hits[name][line.Number] = hits[name][line.Number] || line.Hits > 0
+				}
+			}
+		}
+	}
+
+	files := map[string]coverageFile{}
+	for name, lines := range hits {
+		file := coverageFile{Lines: len(lines)}
+		for number, covered := range lines {
+			if covered {
+				file.Covered++
+			} else {
+				file.Uncovered = append(file.Uncovered, number)
+			}
+		}
+		sort.Ints(file.Uncovered)
+		files[name] = file
+	}
+	return files, nil
+}
+
+// coveragePluginPath returns the path of a report file relative to the collection if it is in `plugins/`.
+// ansible-test reports paths relative to the collection, pytest-cov relative to the sources.
+func coveragePluginPath(filename string, sources []string) string {
+	candidates := []string{filename}
+	for _, source := range sources {
+		candidates = append(candidates, filepath.ToSlash(filepath.Join(source, filename)))
+	}
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, "plugins/") {
+			return candidate
+		}
+		if i := strings.Index(candidate, "/plugins/"); i >= 0 {
+			return candidate[i+1:]
+		}
+	}
+	return ""
+}
+
+func coverageTotal(files map[string]coverageFile) coverageFile {
+	total := coverageFile{}
+	for _, file := range files {
+		total.Lines += file.Lines
+		total.Covered += file.Covered
+	}
+	return total
+}
+
+// coverageCompare formats the baseline of the file (or the total if name is empty) and the difference to it.
+func coverageCompare(percent float64, baseline *coverageBaseline, name string) string {
+	if baseline == nil {
+		return ""
+	}
+	previous, ok := baseline.Total, true
+	if name != "" {
+		previous, ok = baseline.Files[name]
+	}
+	if !ok {
+		return "new"
+	}
+
+	diff := percent - previous
+	switch {
+	case diff < -coverageTolerance:
+		return fmt.Sprintf("%.1f%% 📉 %.1f", previous, diff)
+	case diff > coverageTolerance:
+		return fmt.Sprintf("%.1f%% 📈 +%.1f", previous, diff)
+	default:
+		return fmt.Sprintf("%.1f%%", previous)
+	}
+}
+
+func coverageBaselineRead() (*coverageBaseline, error) {
+	data, err := os.ReadFile(CoverageBaselineFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	baseline := &coverageBaseline{}
+	if err := json.Unmarshal(data, baseline); err != nil {
+		return nil, fmt.Errorf("parsing %q: %w", CoverageBaselineFile, err)
+	}
+	return baseline, nil
+}
+
+func coverageThreshold(env string, fallback float64) (float64, error) {
+	value := os.Getenv(env)
+	if value == "" {
+		return fallback, nil
+	}
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold < 0 || threshold > 100 {
+		return 0, fmt.Errorf("%s must be a percentage between 0 and 100, got %q", env, value)
+	}
+	return threshold, nil
+}
+
+// coverageRanges formats sorted line numbers as ranges, e.g. "3-5, 9".
+func coverageRanges(lines []int) string {
+	ranges := []string{}
+	for i := 0; i < len(lines); {
+		j := i
+		for j+1 < len(lines) && lines[j+1] == lines[j]+1 {
+			j++
+		}
+		if i == j {
+			ranges = append(ranges, strconv.Itoa(lines[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", lines[i], lines[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(ranges, ", ")
+}
+
+func coverageRound(percent float64) float64 {
+	return math.Round(percent*10) / 10
+}
@@ -0,0 +1,448 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+	"golang.org/x/term"
+)
+
+const (
+	// DoctorCategories are the categories of checks, a failing check only fails `mage doctor` if its category is
+	// selected with `DOCTOR_CATEGORIES` (all by default).
+	DoctorCategories = "dev test release"
+)
+
+// yqMajorVersion finds the major version in the output of `yq --version` of mikefarah/yq, e.g. "version v4.40.5".
+var yqMajorVersion = regexp.MustCompile(`version v?(\d+)\.`)
+
+// doctorCheck is the outcome of one check, Status is "ok", "warning" or "failed".
+type doctorCheck struct {
+	Category string `json:"category"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Value    string `json:"value"`
+	Notes    string `json:"notes"`
+
+	fix *doctorFix
+}
+
+// doctorFix remedies a check that did not pass, Apply reports whether it changed anything.
+type doctorFix struct {
+	Description string
+	Destructive bool
+	Apply       func() (bool, error)
+}
+
+// doctorReport collects the checks, failing ones of categories not selected only warn.
+type doctorReport struct {
+	OK         bool          `json:"ok"`
+	Categories []string      `json:"categories"`
+	Checks     []doctorCheck `json:"checks"`
+}
+
+// 🔍 Doctor validates the tools, environment variables and checkout layout needed for development (dev),
+// testing (test) and releases (release). Set `DOCTOR_CATEGORIES` (e.g. "dev,test") to only fail for some
+// categories and `DOCTOR_JSON=true` to print the results as JSON, e.g. in CI.
+// Set `DOCTOR_FIX=true` to remedy what can be fixed automatically, asking before destructive changes
+// unless `DOCTOR_YES=true`.
+func Doctor() error {
+	magetoolsutils.CheckPtermDebug()
+
+	categories, err := doctorCategories(os.Getenv("DOCTOR_CATEGORIES"))
+	if err != nil {
+		return err
+	}
+	asJSON := os.Getenv("DOCTOR_JSON") == "true"
+	if !asJSON {
+		pterm.DefaultHeader.Println("Check Environment")
+	}
+
+	report := doctorRun(categories)
+	var fixErr error
+	if os.Getenv("DOCTOR_FIX") == "true" {
+		var changed bool
+		if asJSON {
+			// Keep stdout for the report, e.g. for `mage doctor | jq`.
+			restore := doctorStdoutToStderr()
+			changed, fixErr = doctorFixAll(report, os.Getenv("DOCTOR_YES") == "true")
+			restore()
+		} else {
+			changed, fixErr = doctorFixAll(report, os.Getenv("DOCTOR_YES") == "true")
+		}
+		if changed {
+			report = doctorRun(categories)
+		}
+	}
+
+	if asJSON {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		doctorPrint(report)
+	}
+
+	failed := 0
+	for _, check := range report.Checks {
+		if check.Status == "failed" {
+			failed++
+		}
+	}
+	if failed > 0 {
+		if !asJSON {
+			pterm.Error.Printfln("required checks failed: %d", failed)
+		}
+		return fmt.Errorf("failed %d checks", failed)
+	}
+	return fixErr
+}
+
+// doctorRun runs every check, checks of categories not selected never fail.
+func doctorRun(categories []string) *doctorReport {
+	report := &doctorReport{Categories: categories}
+
+	report.add("dev", "Go version", true, runtime.Version(), "")
+	report.add("dev", "GOOS/GOARCH", true, runtime.GOOS+"/"+runtime.GOARCH, "")
+	report.add("dev", "GOROOT", true, runtime.GOROOT(), "")
+	report.add("dev", "GOPATH", true, os.Getenv("GOPATH"), "")
+
+	// Same interpreter and supported versions as `mage init`.
+	python, pythonErr := pythonResolve(os.Getenv("PYTHON"))
+	release, releaseErr := ansibleRelease(AnsibleLatest)
+	switch {
+	case pythonErr != nil:
+		report.add("dev", "python3", false, "", pythonErr.Error())
+	case releaseErr != nil:
+		report.add("dev", "python3", false, python.Version+" ("+python.Path+")", releaseErr.Error())
+	case !pythonSupported(release, python.Minor()):
+		supported := ansiblePythons[release]
+		report.add("dev", "python3", false, python.Version+" ("+python.Path+")",
+			fmt.Sprintf("ansible-core %s (%s) supports Python %s to %s, set PYTHON, see `mage pythons`",
+				release, AnsibleLatest, supported[0], supported[1]))
+	default:
+		report.add("dev", "python3", true, python.Version+" ("+python.Path+")", "creates the virtual environment")
+	}
+
+	if version, err := venvOutput("python3", "--version"); err != nil {
+		_, statErr := os.Lstat(venvPath())
+		broken := statErr == nil
+		notes, fix := "missing, run `mage init`", "create"
+		if broken {
+			notes, fix = "broken, run `mage init`", "recreate"
+		}
+		report.add("dev", "virtual environment", false, venvPath(), notes)
+		description, destructive := fix+" the virtual environment with Ansible "+AnsibleLatest, broken
+		// `mage init` clears the keyed virtual environment, which e.g. `mage testMatrix` may have created already.
+		if pythonErr == nil {
+			keyed := venvKeyed(AnsibleLatest, python)
+			if _, statErr := os.Stat(keyed); statErr == nil {
+				description, destructive = description+", wiping "+keyed, true
+			}
+		}
+		report.fixWith(description, destructive, func() (bool, error) { return true, ansibleInit(AnsibleLatest) })
+	} else {
+		value := venvPath()
+		if target, err := os.Readlink(venvPath()); err == nil {
+			value += " -> " + target
+		}
+		report.add("dev", "virtual environment", true, value, version)
+	}
+
+	report.add(doctorDirs())
+	report.fixWith("create the cache and artifact directories and ignore them in .gitignore", false, doctorDirsFix)
+	report.add(doctorYq())
+
+	ansible := fmt.Sprintf("https://github.com/ansible/ansible/archive/%s.tar.gz", AnsibleLatest)
+	report.addTool("test", "ansible-test", ansible, "runs sanity, unit and integration tests, installed by `mage init`")
+	report.add(doctorRuntime())
+	report.add(doctorLayout())
+	report.fixWith("link the checkout into "+filepath.Join(CacheDir, "collections"), false, func() (bool, error) {
+		_, dir, err := collectionLayout()
+		if err != nil {
+			return false, err
+		}
+		pterm.Success.Printfln("linked %q to the checkout", dir)
+		return true, nil
+	})
+
+	report.addTool("release", "ansible-galaxy", ansible, "builds and publishes the collection, installed by `mage init`")
+	report.addTool("release", "antsibull-changelog", "antsibull-changelog", "generates the changelog, installed by `mage changelog`")
+	report.addEnv("release", "GALAXY_SERVER", false, "required for defining target publish location")
+	report.addEnv("release", "GALAXY_KEY", true, "required for publishing")
+
+	report.OK = true
+	for _, check := range report.Checks {
+		if check.Status == "failed" {
+			report.OK = false
+		}
+	}
+	return report
+}
+
+// add records a check, failed checks of categories not selected are warnings.
+func (r *doctorReport) add(category, name string, ok bool, value, notes string) {
+	status := "ok"
+	if !ok {
+		status = "warning"
+		for _, selected := range r.Categories {
+			if selected == category {
+				status = "failed"
+			}
+		}
+	}
+	r.Checks = append(r.Checks, doctorCheck{Category: category, Name: name, Status: status, Value: value, Notes: notes})
+}
+
+// fixWith sets the fix of the last check if it did not pass.
+func (r *doctorReport) fixWith(description string, destructive bool, apply func() (bool, error)) {
+	last := &r.Checks[len(r.Checks)-1]
+	if last.Status != "ok" {
+		last.fix = &doctorFix{Description: description, Destructive: destructive, Apply: apply}
+	}
+}
+
+// addTool checks that the tool is installed in the virtual environment, showing the first line of its version.
+// The fix installs pkg providing it.
+func (r *doctorReport) addTool(category, name, pkg, notes string) {
+	output, err := venvOutput(name, "--version")
+	if err != nil {
+		r.add(category, name, false, "", "missing, "+notes)
+		r.fixWith(fmt.Sprintf("install %s into the virtual environment", name), false, func() (bool, error) {
+			// An earlier fix, e.g. of the virtual environment, may have installed it already.
+			if venvBinExists(name) || !venvExists() {
+				return false, nil
+			}
+			return true, venvInstall(pkg)
+		})
+		return
+	}
+	r.add(category, name, true, strings.Split(output, "\n")[0], notes)
+}
+
+// addEnv checks that the environment variable is set, without showing secrets.
+func (r *doctorReport) addEnv(category, name string, secret bool, notes string) {
+	value, ok := os.LookupEnv(name)
+	if ok && secret {
+		value = "***** secret set, but not logged *****"
+	}
+	r.add(category, name, ok, value, notes)
+}
+
+// doctorDirs checks that the cache and artifact directories exist and git ignores them.
+func doctorDirs() (string, string, bool, string, string) {
+	missing := doctorDirsMissing()
+	if len(missing) > 0 {
+		return "dev", "cache directories", false, strings.Join(missing, ", "), "missing or not ignored by git"
+	}
+	return "dev", "cache directories", true, strings.Join(doctorDirsIgnored, ", "), "ignored by git"
+}
+
+// doctorDirsIgnored are the directories written by the targets, which must not be committed.
+var doctorDirsIgnored = []string{CacheDir, ArtifactDir, "tests/output"}
+
+func doctorDirsMissing() []string {
+	missing := []string{}
+	for _, dir := range doctorDirsIgnored {
+		_, statErr := os.Stat(dir)
+		if (dir != "tests/output" && statErr != nil) || sh.Run("git", "check-ignore", "--quiet", dir+"/") != nil {
+			missing = append(missing, dir)
+		}
+	}
+	return missing
+}
+
+// doctorDirsFix creates the missing directories and appends the ones not ignored to .gitignore.
+func doctorDirsFix() (bool, error) {
+	changed := false
+	for _, dir := range []string{CacheDir, ArtifactDir} {
+		if _, err := os.Stat(dir); err != nil {
+			if err := mkdir(dir); err != nil {
+				return changed, err
+			}
+			pterm.Success.Printfln("created %q", dir)
+			changed = true
+		}
+	}
+
+	entries := []string{}
+	for _, dir := range doctorDirsIgnored {
+		if sh.Run("git", "check-ignore", "--quiet", dir+"/") != nil {
+			entries = append(entries, "/"+dir+"/")
+		}
+	}
+	if len(entries) == 0 {
+		return changed, nil
+	}
+	const permBits = 0o644
+	file, err := os.OpenFile(".gitignore", os.O_APPEND|os.O_CREATE|os.O_WRONLY, permBits)
+	if err != nil {
+		return changed, err
+	}
+	defer file.Close()
+	if _, err := fmt.Fprintf(file, "\n# Written by mage targets\n%s\n", strings.Join(entries, "\n")); err != nil {
+		return changed, err
+	}
+	pterm.Success.Printfln("added %s to .gitignore", strings.Join(entries, ", "))
+	return true, nil
+}
+
+// doctorRuntime checks for a container runtime, not needed when `TEST_MODE` selects a mode without containers.
+func doctorRuntime() (string, string, bool, string, string) {
+	for _, name := range []string{TestModeDocker, TestModePodman} {
+		if testRuntimeAvailable(name) {
+			version, _ := sh.Output(name, "--version")
+			return "test", "container runtime", true, version, "ansible-test runs in containers"
+		}
+	}
+	switch mode := strings.ToLower(os.Getenv("TEST_MODE")); mode {
+	case TestModeVenv, TestModeLocal:
+		return "test", "container runtime", true, "", fmt.Sprintf("not needed with TEST_MODE=%s", mode)
+	}
+	return "test", "container runtime", false, "", "neither docker nor podman is available, set TEST_MODE=venv to test without"
+}
+
+// doctorLayout checks where ansible-test runs: the checkout if stored below `ansible_collections/`,
+// the link of collectionLayout otherwise, which the fix and the test targets create.
+func doctorLayout() (string, string, bool, string, string) {
+	inLayout, err := collectionInLayout()
+	if err != nil {
+		return "test", "collection layout", false, "", err.Error()
+	}
+	checkout, err := os.Getwd()
+	if err != nil {
+		return "test", "collection layout", false, "", err.Error()
+	}
+	if inLayout {
+		return "test", "collection layout", true, checkout, ""
+	}
+	namespace, name, _ := collectionName()
+	link := filepath.Join(CacheDir, "collections", "ansible_collections", namespace, name)
+	if target, err := os.Readlink(link); err == nil && target == checkout {
+		return "test", "collection layout", true, link, "linked to the checkout, ansible-test runs from there"
+	}
+	return "test", "collection layout", false, checkout, "not below ansible_collections/ and not linked to " + link
+}
+
+// doctorYq checks for mikefarah/yq v4, the Python yq has different flags.
+func doctorYq() (string, string, bool, string, string) {
+	const notes = "reads fixtures, edits galaxy.yml and reads the changelog, install mikefarah/yq v4 with aqua"
+	output, err := sh.Output("yq", "--version")
+	if err != nil {
+		return "dev", "yq", false, "", "missing, " + notes
+	}
+	if !strings.Contains(output, "mikefarah") {
+		return "dev", "yq", false, output, "unsupported flavour, " + notes
+	}
+	match := yqMajorVersion.FindStringSubmatch(output)
+	if match == nil {
+		return "dev", "yq", false, output, "unknown version, " + notes
+	}
+	if major, _ := strconv.Atoi(match[1]); major < 4 {
+		return "dev", "yq", false, output, "unsupported version, " + notes
+	}
+	return "dev", "yq", true, output, ""
+}
+
+// doctorFixAll applies the fixes of the checks that did not pass, asking before destructive ones unless yes is set.
+// Checks without fix are listed to be fixed by hand.
+func doctorFixAll(report *doctorReport, yes bool) (bool, error) {
+	pterm.DefaultSection.Println("Fixes")
+
+	changed := false
+	failed := []string{}
+	for _, check := range report.Checks {
+		if check.Status == "ok" {
+			continue
+		}
+		if check.fix == nil {
+			pterm.Warning.Printfln("%s: fix by hand, %s", check.Name, check.Notes)
+			continue
+		}
+		if check.fix.Destructive && !yes {
+			confirmed, err := doctorConfirm(check.fix.Description)
+			if err != nil {
+				return changed, err
+			}
+			if !confirmed {
+				pterm.Warning.Printfln("%s: skipped %s (set DOCTOR_YES=true to skip the question)", check.Name, check.fix.Description)
+				continue
+			}
+		}
+		applied, err := check.fix.Apply()
+		if err != nil {
+			pterm.Error.Printfln("%s: %s: %v", check.Name, check.fix.Description, err)
+			failed = append(failed, check.Name)
+			continue
+		}
+		if applied {
+			pterm.Success.Printfln("%s: %s", check.Name, check.fix.Description)
+			changed = true
+		}
+	}
+	if !changed {
+		pterm.Info.Println("nothing changed")
+	}
+	if len(failed) > 0 {
+		return changed, fmt.Errorf("fixing %s failed", strings.Join(failed, ", "))
+	}
+	return changed, nil
+}
+
+// doctorStdoutToStderr sends pterm and command output to stderr until the returned function restores stdout.
+func doctorStdoutToStderr() func() {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	pterm.SetDefaultOutput(os.Stderr)
+	return func() {
+		os.Stdout = stdout
+		pterm.SetDefaultOutput(stdout)
+	}
+}
+
+// doctorConfirm asks whether to apply a destructive fix, refusing when stdin is not a terminal.
+func doctorConfirm(description string) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, nil
+	}
+	return pterm.DefaultInteractiveConfirm.Show(description + "?")
+}
+
+// doctorCategories parses the categories separated by commas or spaces, all if empty.
+func doctorCategories(value string) ([]string, error) {
+	categories := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(categories) == 0 {
+		return strings.Fields(DoctorCategories), nil
+	}
+	for _, category := range categories {
+		if !strings.Contains(" "+DoctorCategories+" ", " "+category+" ") {
+			return nil, fmt.Errorf("unknown category %q in DOCTOR_CATEGORIES, expected some of: %s", category, DoctorCategories)
+		}
+	}
+	return categories, nil
+}
+
+func doctorPrint(report *doctorReport) {
+	icons := map[string]string{"ok": "✅", "warning": "👉", "failed": "❌"}
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	tbl := pterm.TableData{[]string{"Status", "Category", "Check", "Value", "Notes"}}
+	for _, check := range report.Checks {
+		tbl = append(tbl, []string{icons[check.Status], check.Category, check.Name, check.Value, check.Notes})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+}
@@ -0,0 +1,704 @@
+//go:build mage
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+// ----------------------------------- //
+//          DSV API Stand-in           //
+// ----------------------------------- //
+
+const (
+	// DSVMockAddr is the default listen address of `mage dsv:mock`, override with `DSV_MOCK_ADDR`.
+	DSVMockAddr = "127.0.0.1:8898"
+
+	// DSVMockPoll is how often `mage dsv:mock` checks the fixtures for changes.
+	DSVMockPoll = time.Second
+)
+
+// Dsv contains targets for working with the DSV stand-in.
+type Dsv mg.Namespace
+
+// 🧪 Mock runs the DSV stand-in in the foreground with the fixtures in dir (e.g. "tests/e2e/fixtures"),
+// reloading them when files change.
+func (Dsv) Mock(dir string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("DSV stand-in")
+
+	addr := os.Getenv("DSV_MOCK_ADDR")
+	if addr == "" {
+		addr = DSVMockAddr
+	}
+
+	fixture, err := dsvFixtureReadDir(dir)
+	if err != nil {
+		return err
+	}
+	stamp, err := dsvFixtureStamp(dir)
+	if err != nil {
+		return err
+	}
+
+	server := newDSVServer(fixture)
+	server.Verbose = true
+	if err := server.Start(addr); err != nil {
+		return err
+	}
+	defer server.Close()
+
+	pterm.Info.Printfln(
+		"serving %d secrets from %q, export:\n\texport DSV_TENANT=mock\n\texport DSV_URL_TEMPLATE=%s\n"+
+			"\texport DSV_CLIENT_ID=%s\n\texport DSV_CLIENT_SECRET=%s",
+		len(fixture.Secrets), dir, server.URLTemplate(), fixture.Credentials.ClientID, fixture.Credentials.ClientSecret,
+	)
+	pterm.Info.Println("press Ctrl+C to stop")
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(DSVMockPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-interrupt:
+			pterm.Info.Println("dsv stand-in stopped")
+			return nil
+
+		case <-ticker.C:
+			current, err := dsvFixtureStamp(dir)
+			if err != nil || current == stamp {
+				continue
+			}
+			stamp = current
+			// Keep serving the previous fixtures until the files are valid again.
+			fixture, err := dsvFixtureReadDir(dir)
+			if err != nil {
+				pterm.Error.Printfln("reloading fixtures: %v", err)
+				continue
+			}
+			server.SetFixture(fixture)
+			pterm.Success.Printfln("reloaded %d secrets from %q", len(fixture.Secrets), dir)
+		}
+	}
+}
+
+// dsvFixture is the content of a mock DSV fixture file: accepted client credentials and secrets by path.
+//
+//nolint:tagliatelle // Fixture keys match the option names of the lookup plugin.
+type dsvFixture struct {
+	Credentials struct {
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	} `json:"credentials"`
+	Secrets map[string]map[string]interface{} `json:"secrets"`
+	Faults  []dsvFault                        `json:"faults,omitempty"`
+
+	// Token holds additional fields of the token response, e.g. recorded by `mage dsv:record`.
+	Token map[string]interface{} `json:"token,omitempty"`
+}
+
+// dsvFault makes the stand-in misbehave for matching requests.
+// Endpoint is "token" or "secrets" and Path a secret path, empty values match every request.
+//
+//nolint:tagliatelle // Fixture keys use snake_case like the rest of the fixture.
+type dsvFault struct {
+	Endpoint   string `json:"endpoint"`
+	Path       string `json:"path"`
+	Status     int    `json:"status"`
+	Message    string `json:"message"`
+	RetryAfter int    `json:"retry_after"`
+	Delay      string `json:"delay"`
+	Body       string `json:"body"`
+}
+
+const (
+	// dsvFaultTruncated cuts the response body in half.
+	dsvFaultTruncated = "truncated"
+
+	// dsvFaultMalformed replaces the response body with invalid JSON.
+	dsvFaultMalformed = "malformed"
+)
+
+// dsvServer is an in-process stand-in for the DSV REST API used by python-dsv-sdk:
+// `POST /v1/token` with client credentials and `GET /v1/secrets/{path}` with the issued bearer token.
+// Secrets can also be created (`POST`), updated (`PUT`) and deleted (`DELETE`), e.g. by `mage dsv:seed`.
+type dsvServer struct {
+	URL    string
+	TLSURL string
+
+	// Verbose logs every request instead of only in debug mode.
+	Verbose bool
+
+	mu       sync.Mutex
+	fixture  *dsvFixture
+	faults   []dsvFault
+	tokens   map[string]bool
+	requests map[string]int
+	servers  []*http.Server
+}
+
+func newDSVServer(fixture *dsvFixture) *dsvServer {
+	return &dsvServer{fixture: fixture, faults: fixture.Faults, tokens: map[string]bool{}, requests: map[string]int{}}
+}
+
+// Requests returns the number of requests by endpoint ("token", "secrets" or "other") since the last call.
+func (s *dsvServer) Requests() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests := s.requests
+	s.requests = map[string]int{}
+	return requests
+}
+
+// SetFaults replaces the faults of the fixture, e.g. with the faults of a single test scenario.
+func (s *dsvServer) SetFaults(faults []dsvFault) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = faults
+}
+
+// SetFixture replaces the credentials, secrets and faults served. Issued tokens stay valid.
+func (s *dsvServer) SetFixture(fixture *dsvFixture) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixture = fixture
+	s.faults = fixture.Faults
+}
+
+// Start listens on addr (e.g. "127.0.0.1:0") and serves the API in the background.
+func (s *dsvServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.URL = "http://" + listener.Addr().String() + "/"
+	s.serve(listener, nil)
+	pterm.Info.Printfln("dsv stand-in listening on %s", s.URL)
+	return nil
+}
+
+// StartTLS additionally serves the API over TLS with a freshly generated self-signed certificate,
+// which clients are expected to reject.
+func (s *dsvServer) StartTLS(addr string) error {
+	certificate, err := selfSignedCertificate()
+	if err != nil {
+		return err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.TLSURL = "https://" + listener.Addr().String() + "/"
+	s.serve(listener, &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12})
+	pterm.Info.Printfln("dsv stand-in listening on %s (self-signed)", s.TLSURL)
+	return nil
+}
+
+func (s *dsvServer) serve(listener net.Listener, tlsConfig *tls.Config) {
+	server := &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second, TLSConfig: tlsConfig}
+	s.servers = append(s.servers, server)
+
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			err = server.ServeTLS(listener, "", "")
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			pterm.Error.Printfln("dsv stand-in stopped: %v", err)
+		}
+	}()
+}
+
+// Close stops the server.
+func (s *dsvServer) Close() error {
+	for _, server := range s.servers {
+		if err := server.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// URLTemplate returns the value for `DSV_URL_TEMPLATE` pointing the lookup plugin at the server.
+// Tenant and TLD are not used by the stand-in, Python's str.format ignores the extra arguments.
+func (s *dsvServer) URLTemplate() string {
+	return s.URL + "v1"
+}
+
+// TLSURLTemplate is like URLTemplate, but points to the self-signed TLS listener.
+func (s *dsvServer) TLSURLTemplate() string {
+	return s.TLSURL + "v1"
+}
+
+func (s *dsvServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	status := &dsvStatusWriter{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		printer := pterm.Debug
+		if s.Verbose {
+			printer = pterm.Info
+		}
+		printer.Printfln("dsv stand-in: %s %s %d (took: %s)",
+			r.Method, r.URL.Path, status.status, time.Since(now).Round(time.Millisecond))
+	}()
+
+	endpoint, _ := dsvEndpoint(r)
+	s.mu.Lock()
+	s.requests[endpoint]++
+	s.mu.Unlock()
+
+	if fault, ok := s.fault(r); ok && s.inject(status, r, fault) {
+		return
+	}
+	s.handle(status, r)
+}
+
+// dsvStatusWriter remembers the status code for the request log.
+type dsvStatusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *dsvStatusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (s *dsvServer) handle(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/v1/token" && r.Method == http.MethodPost:
+		s.handleToken(w, r)
+
+	case strings.HasPrefix(r.URL.Path, "/v1/secrets/"):
+		s.handleSecret(w, r, strings.TrimPrefix(r.URL.Path, "/v1/secrets/"))
+
+	default:
+		dsvError(w, http.StatusNotFound, "not found: %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func (s *dsvServer) handleToken(w http.ResponseWriter, r *http.Request) {
+	//nolint:tagliatelle // DSV API uses snake_case keys for the token request.
+	request := struct {
+		GrantType    string `json:"grant_type"`
+		ClientID     string `json:"client_id"`
+		ClientSecret string `json:"client_secret"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		dsvError(w, http.StatusBadRequest, "invalid token request: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credentials := s.fixture.Credentials
+	if request.GrantType != "client_credentials" ||
+		request.ClientID != credentials.ClientID || request.ClientSecret != credentials.ClientSecret {
+		dsvError(w, http.StatusUnauthorized, "unable to authenticate")
+		return
+	}
+
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		dsvError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	accessToken := hex.EncodeToString(token)
+	s.tokens[accessToken] = true
+
+	response := map[string]interface{}{"tokenType": "bearer", "expiresIn": 3600}
+	for key, value := range s.fixture.Token {
+		response[key] = value
+	}
+	response["accessToken"] = accessToken
+	dsvRespond(w, http.StatusOK, response)
+}
+
+func (s *dsvServer) handleSecret(w http.ResponseWriter, r *http.Request, path string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tokens[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+		dsvError(w, http.StatusUnauthorized, "invalid or expired access token")
+		return
+	}
+
+	path = dsvPath(path)
+	fixturePath, secret, found := "", map[string]interface{}(nil), false
+	for candidate, value := range s.fixture.Secrets {
+		if dsvPath(candidate) == path {
+			fixturePath, secret, found = candidate, value, true
+			break
+		}
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if !found {
+			dsvError(w, http.StatusNotFound, "unable to find item with specified identifiers")
+			return
+		}
+		dsvRespond(w, http.StatusOK, dsvSecret(path, secret))
+
+	case http.MethodPost, http.MethodPut:
+		if r.Method == http.MethodPost && found {
+			dsvError(w, http.StatusBadRequest, "item already exists")
+			return
+		}
+		if r.Method == http.MethodPut && !found {
+			dsvError(w, http.StatusNotFound, "unable to find item with specified identifiers")
+			return
+		}
+		update := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			dsvError(w, http.StatusBadRequest, "invalid secret: %v", err)
+			return
+		}
+		written := dsvSecret(path, secret)
+		for _, key := range []string{"data", "description", "attributes"} {
+			if value, ok := update[key]; ok {
+				written[key] = value
+			}
+		}
+		now := time.Now().UTC().Format(time.RFC3339)
+		if !found {
+			written["created"] = now
+		}
+		written["lastModified"] = now
+		written["version"] = dsvVersionNext(written["version"])
+
+		delete(s.fixture.Secrets, fixturePath)
+		s.fixture.Secrets[path] = written
+		dsvRespond(w, http.StatusOK, written)
+
+	case http.MethodDelete:
+		if !found {
+			dsvError(w, http.StatusNotFound, "unable to find item with specified identifiers")
+			return
+		}
+		delete(s.fixture.Secrets, fixturePath)
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		dsvError(w, http.StatusMethodNotAllowed, "method %s not allowed", r.Method)
+	}
+}
+
+// dsvVersionNext increments the version of a secret, which DSV returns as string.
+func dsvVersionNext(version interface{}) string {
+	current, err := strconv.Atoi(fmt.Sprint(version))
+	if err != nil {
+		return "0"
+	}
+	return strconv.Itoa(current + 1)
+}
+
+// fault returns the first configured fault matching the request.
+func (s *dsvServer) fault(r *http.Request) (dsvFault, bool) {
+	endpoint, path := dsvEndpoint(r)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, fault := range s.faults {
+		if (fault.Endpoint == "" || fault.Endpoint == endpoint) && (fault.Path == "" || dsvPath(fault.Path) == path) {
+			return fault, true
+		}
+	}
+	return dsvFault{}, false
+}
+
+// dsvEndpoint returns the endpoint of the request ("token", "secrets" or "other") and the secret path if any.
+func dsvEndpoint(r *http.Request) (string, string) {
+	switch {
+	case r.URL.Path == "/v1/token":
+		return "token", ""
+	case strings.HasPrefix(r.URL.Path, "/v1/secrets/"):
+		return "secrets", dsvPath(strings.TrimPrefix(r.URL.Path, "/v1/secrets/"))
+	}
+	return "other", ""
+}
+
+// inject applies the fault and reports whether it already wrote the response.
+func (s *dsvServer) inject(w http.ResponseWriter, r *http.Request, fault dsvFault) bool {
+	if fault.Delay != "" {
+		delay, err := time.ParseDuration(fault.Delay)
+		if err != nil {
+			dsvError(w, http.StatusInternalServerError, "invalid fault delay %q: %v", fault.Delay, err)
+			return true
+		}
+		pterm.Debug.Printfln("dsv stand-in: delaying %s by %s", r.URL.Path, delay)
+		time.Sleep(delay)
+	}
+
+	if fault.RetryAfter > 0 {
+		w.Header().Set("Retry-After", fmt.Sprint(fault.RetryAfter))
+	}
+
+	recorder := httptest.NewRecorder()
+	switch {
+	case fault.Status != 0:
+		message := fault.Message
+		if message == "" {
+			message = strings.ToLower(http.StatusText(fault.Status))
+		}
+		dsvError(recorder, fault.Status, "%s", message)
+
+	case fault.Body != "":
+		s.handle(recorder, r)
+
+	default:
+		return false
+	}
+
+	body := recorder.Body.Bytes()
+	switch fault.Body {
+	case "":
+	case dsvFaultTruncated:
+		body = body[:len(body)/2]
+	case dsvFaultMalformed:
+		body = []byte(`{"message": malformed`)
+	default:
+		dsvError(w, http.StatusInternalServerError, "unknown body fault %q, expected %q or %q", fault.Body, dsvFaultTruncated, dsvFaultMalformed)
+		return true
+	}
+
+	for key, values := range recorder.Header() {
+		w.Header()[key] = values
+	}
+	w.WriteHeader(recorder.Code)
+	if _, err := w.Write(body); err != nil {
+		pterm.Error.Printfln("dsv stand-in: writing response: %v", err)
+	}
+	return true
+}
+
+// dsvSecret fills in the fields DSV returns for every secret, fixture values take precedence.
+func dsvSecret(path string, fixture map[string]interface{}) map[string]interface{} {
+	secret := map[string]interface{}{
+		"id":             fmt.Sprintf("mock-%x", []byte(path)),
+		"path":           strings.ReplaceAll(path, "/", ":"),
+		"attributes":     map[string]interface{}{},
+		"description":    "",
+		"data":           map[string]interface{}{},
+		"created":        "2023-01-01T00:00:00Z",
+		"lastModified":   "2023-01-01T00:00:00Z",
+		"createdBy":      "users:mock",
+		"lastModifiedBy": "users:mock",
+		"version":        "0",
+	}
+	for key, value := range fixture {
+		secret[key] = value
+	}
+	return secret
+}
+
+// dsvPath normalizes a secret path, DSV accepts both ":" and "/" as separators.
+func dsvPath(path string) string {
+	return strings.Trim(strings.ReplaceAll(path, ":", "/"), "/")
+}
+
+func dsvRespond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		pterm.Error.Printfln("dsv stand-in: writing response: %v", err)
+	}
+}
+
+func dsvError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	pterm.Debug.Printfln("dsv stand-in: %d %s", status, message)
+	dsvRespond(w, status, map[string]interface{}{"code": status, "message": message})
+}
+
+// dsvFixtureRead loads a fixture from a JSON or YAML file.
+func dsvFixtureRead(path string) (*dsvFixture, error) {
+	fixture := &dsvFixture{}
+	if err := fixtureRead(path, fixture); err != nil {
+		return nil, err
+	}
+	if fixture.Secrets == nil {
+		fixture.Secrets = map[string]map[string]interface{}{}
+	}
+	return fixture, nil
+}
+
+// dsvFixtureReadDir merges the fixture files in dir. Credentials of later files (sorted by name) win,
+// secret paths must be unique. Recordings in DSVRecordDir are read last: their credentials are only used if no
+// other file defines some, and a recorded secret of a hand-written path only adds the fields the hand-written
+// one lacks (e.g. attributes or version), its redacted data never replaces the hand-written values.
+func dsvFixtureReadDir(dir string) (*dsvFixture, error) {
+	files, err := dsvFixtureFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no fixtures (*.json, *.yml, *.yaml) found in %q", dir)
+	}
+
+	merged := &dsvFixture{Secrets: map[string]map[string]interface{}{}}
+	origin := map[string]string{}
+	for _, file := range files {
+		fixture, err := dsvFixtureRead(file)
+		if err != nil {
+			return nil, err
+		}
+		recorded := filepath.Base(filepath.Dir(file)) == DSVRecordDir
+		if fixture.Credentials.ClientID != "" && (!recorded || merged.Credentials.ClientID == "") {
+			merged.Credentials = fixture.Credentials
+		}
+		if fixture.Token != nil {
+			merged.Token = fixture.Token
+		}
+		for path, secret := range fixture.Secrets {
+			previous, ok := origin[dsvPath(path)]
+			if !ok {
+				origin[dsvPath(path)] = file
+				merged.Secrets[path] = secret
+				continue
+			}
+			if !recorded {
+				return nil, fmt.Errorf("secret %q defined in %q and %q", path, previous, file)
+			}
+			pterm.Debug.Printfln("secret %q of %q completed by the recording in %q", path, previous, file)
+			for existing, handWritten := range merged.Secrets {
+				if dsvPath(existing) != dsvPath(path) {
+					continue
+				}
+				for key, value := range secret {
+					if _, ok := handWritten[key]; !ok {
+						handWritten[key] = value
+					}
+				}
+			}
+		}
+		merged.Faults = append(merged.Faults, fixture.Faults...)
+	}
+	if merged.Credentials.ClientID == "" {
+		return nil, fmt.Errorf("no fixture in %q defines credentials", dir)
+	}
+	return merged, nil
+}
+
+// dsvFixtureFiles returns the sorted fixture files in dir, followed by those in its DSVRecordDir.
+func dsvFixtureFiles(dir string) ([]string, error) {
+	files := []string{}
+	for _, fixtureDir := range []string{dir, filepath.Join(dir, DSVRecordDir)} {
+		entries, err := os.ReadDir(fixtureDir)
+		if errors.Is(err, os.ErrNotExist) && fixtureDir != dir {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			switch strings.ToLower(filepath.Ext(entry.Name())) {
+			case ".json", ".yml", ".yaml":
+				if !entry.IsDir() {
+					files = append(files, filepath.Join(fixtureDir, entry.Name()))
+				}
+			}
+		}
+	}
+	return files, nil
+}
+
+// dsvFixtureStamp summarizes names, sizes and modification times of the fixture files to detect changes.
+func dsvFixtureStamp(dir string) (string, error) {
+	files, err := dsvFixtureFiles(dir)
+	if err != nil {
+		return "", err
+	}
+	stamp := &strings.Builder{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(stamp, "%s:%d:%d\n", file, info.Size(), info.ModTime().UnixNano())
+	}
+	return stamp.String(), nil
+}
+
+// fixtureRead decodes a JSON or YAML file into v. YAML is converted to JSON with yq.
+func fixtureRead(path string, v interface{}) error {
+	var data []byte
+	var err error
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		data, err = os.ReadFile(path)
+	case ".yml", ".yaml":
+		var output string
+		output, err = sh.Output("yq", "-o=json", path)
+		data = []byte(output)
+	default:
+		return fmt.Errorf("unsupported fixture format %q, expected .json, .yml or .yaml", path)
+	}
+	if err != nil {
+		return fmt.Errorf("reading fixture %q: %w", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("parsing fixture %q: %w", path, err)
+	}
+	return nil
+}
+
+// selfSignedCertificate creates a short-lived certificate for localhost signed by itself.
+func selfSignedCertificate() (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "dsv stand-in"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,215 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+// ⚡ TestFast runs unit tests with pytest directly in the virtual environment, without ansible-test containers.
+// Pass "all" to run every test, a keyword expression (e.g. "data_key") or a test path / node id to select tests.
+func TestFast(selection string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("pytest units")
+
+	if !venvExists() {
+		pterm.Error.Println("run `mage init` first")
+		return nil
+	}
+	if err := fastInstall(); err != nil {
+		return err
+	}
+
+	keyword, paths := "", []string(nil)
+	switch {
+	case selection == "" || selection == "all":
+	case strings.Contains(selection, "::") || strings.HasSuffix(selection, ".py") || strings.Contains(selection, "/"):
+		paths = []string{selection}
+	default:
+		keyword = selection
+	}
+
+	now := time.Now()
+	if err := fastRun(keyword, paths, os.Stdout); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("unit tests (took: %s)", time.Since(now))
+	return nil
+}
+
+// fastInstall installs the packages needed to run pytest directly.
+func fastInstall() error { return fastInstallIn(venvPath()) }
+
+func fastInstallIn(venv string) error {
+	for _, name := range []string{"pytest", "pytest-cov", "mock"} {
+		runnable, env := venvCommandIn(venv, nil, "pip3")
+		if _, err := sh.OutputWith(env, runnable, "show", "--quiet", name); err != nil {
+			if err := venvInstallIn(venv, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// fastRun runs pytest for the test paths (relative to the collection, all unit tests if none) matching keyword.
+func fastRun(keyword string, paths []string, output io.Writer) error {
+	return fastRunIn(venvPath(), keyword, paths, output)
+}
+
+// fastRunIn runs pytest like fastRun with the virtual environment given.
+func fastRunIn(venvDir, keyword string, paths []string, output io.Writer) error {
+	root, dir, err := collectionLayout()
+	if err != nil {
+		return err
+	}
+
+	python, _, err := testPythonDetect(venvDir)
+	if err != nil {
+		return err
+	}
+
+	// Paths are passed through the layout, so pytest sees the tests below `ansible_collections/`.
+	reports := filepath.Join(dir, "tests", "output")
+	args := []string{
+		"-m", "pytest", "-r", "a", "--color", "yes", "-p", "no:cacheprovider", "--strict-markers",
+		"-p", "ansible_test._util.target.pytest.plugins.ansible_pytest_collections",
+		"--rootdir", dir,
+		"--junit-xml", filepath.Join(reports, "junit", fmt.Sprintf("python%s-fast-units.xml", python)),
+		"--cov", filepath.Join(dir, "plugins"), "--cov-report", "term-missing",
+		"--cov-report", "xml:" + filepath.Join(reports, "reports", fmt.Sprintf("coverage=fast=python-%s.xml", python)),
+	}
+	if keyword != "" {
+		args = append(args, "-k", keyword)
+	}
+	if len(paths) == 0 {
+		paths = []string{filepath.Join("tests", "unit")}
+	}
+	for _, path := range paths {
+		args = append(args, filepath.Join(dir, path))
+	}
+
+	venv, err := filepath.Abs(venvDir)
+	if err != nil {
+		return err
+	}
+	target := testTarget{Venv: venv, Dir: dir, Output: output}
+	command, env := venvCommandIn(venv, map[string]string{"ANSIBLE_COLLECTIONS_PATH": root}, "python3")
+
+	pterm.Debug.Printfln("running from %q", dir)
+	return target.runWith(true, env, command, args...)
+}
+
+// collectionName returns the namespace and name of the collection from galaxy.yml.
+func collectionName() (string, string, error) {
+	data, err := os.ReadFile("galaxy.yml")
+	if err != nil {
+		return "", "", err
+	}
+	fields := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		if key, value, ok := strings.Cut(line, ":"); ok && (key == "namespace" || key == "name") {
+			fields[key] = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+	if fields["namespace"] == "" || fields["name"] == "" {
+		return "", "", fmt.Errorf("galaxy.yml does not define namespace and name")
+	}
+	return fields["namespace"], fields["name"], nil
+}
+
+// collectionLayout links the checkout into `ansible_collections/<namespace>/<name>` below the cache directory,
+// returning the collections root and the collection directory inside it.
+func collectionLayout() (string, string, error) {
+	checkout, err := os.Getwd()
+	if err != nil {
+		return "", "", err
+	}
+	namespace, name, err := collectionName()
+	if err != nil {
+		return "", "", err
+	}
+	root, err := filepath.Abs(filepath.Join(CacheDir, "collections"))
+	if err != nil {
+		return "", "", err
+	}
+	dir := filepath.Join(root, "ansible_collections", namespace, name)
+
+	if target, err := os.Readlink(dir); err == nil && target == checkout {
+		return root, dir, nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", "", err
+	}
+	if err := mkdir(filepath.Dir(dir)); err != nil {
+		return "", "", err
+	}
+	if err := os.Symlink(checkout, dir); err != nil {
+		return "", "", fmt.Errorf("linking %q to %q: %w", dir, checkout, err)
+	}
+	pterm.Debug.Printfln("linked %q to %q", dir, checkout)
+	return root, dir, nil
+}
+
+// collectionInLayout reports whether the checkout is stored in `{...}/ansible_collections/<namespace>/<name>`
+// as ansible-test requires.
+func collectionInLayout() (bool, error) {
+	checkout, err := os.Getwd()
+	if err != nil {
+		return false, err
+	}
+	namespace, name, err := collectionName()
+	if err != nil {
+		return false, err
+	}
+	expected := filepath.Join("ansible_collections", namespace, name)
+	return strings.HasSuffix(checkout, string(filepath.Separator)+expected), nil
+}
+
+// collectionDir returns the directory to run ansible-test from: the checkout if it is stored in the layout
+// ansible-test requires, the link of collectionLayout otherwise. Files written through the link end up in the checkout.
+func collectionDir() (string, error) {
+	inLayout, err := collectionInLayout()
+	if err != nil || inLayout {
+		return ".", err
+	}
+	_, dir, err := collectionLayout()
+	if err != nil {
+		return "", err
+	}
+	pterm.Info.Printfln("checkout is not below ansible_collections/, running ansible-test from %q", dir)
+	return dir, nil
+}
+
+// collectionRepoPath maps a path through the link of collectionLayout back to the checkout,
+// other paths are returned unchanged.
+func collectionRepoPath(path string) string {
+	namespace, name, err := collectionName()
+	if err != nil {
+		return path
+	}
+	link := filepath.Join(CacheDir, "collections", "ansible_collections", namespace, name)
+	absolute, err := filepath.Abs(link)
+	if err != nil {
+		return path
+	}
+	for _, prefix := range []string{absolute, link} {
+		switch {
+		case path == prefix:
+			return "."
+		case strings.HasPrefix(path, prefix+string(filepath.Separator)):
+			return strings.TrimPrefix(path, prefix+string(filepath.Separator))
+		}
+	}
+	return path
+}
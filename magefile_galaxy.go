@@ -0,0 +1,816 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/magefile/mage/mg"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// GalaxyVerifyAttempts is how many times the published version is requested before giving up.
+	// Galaxy imports collections asynchronously, so a fresh version may not be visible right away.
+	GalaxyVerifyAttempts = 10
+
+	// GalaxyVerifyDelay is the pause between two attempts to fetch the published version.
+	GalaxyVerifyDelay = 3 * time.Second
+
+	// GalaxyServeAddr is the default listen address of `mage galaxy:serve`, override with `GALAXY_SERVE_ADDR`.
+	GalaxyServeAddr = "127.0.0.1:8899"
+
+	// galaxyUploadLimit is the maximum size of an uploaded archive kept in memory by the stand-in.
+	galaxyUploadLimit = 32 << 20
+)
+
+// ----------------------------------- //
+//         Galaxy API Stand-in         //
+// ----------------------------------- //
+
+// Galaxy contains targets for working with a local Galaxy stand-in.
+type Galaxy mg.Namespace
+
+// 🌌 Serve runs a local Galaxy v3 API serving the archives from '.artifacts/' until interrupted.
+func (Galaxy) Serve() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Galaxy stand-in")
+
+	if err := mkdir(ArtifactDir); err != nil {
+		return err
+	}
+	addr := os.Getenv("GALAXY_SERVE_ADDR")
+	if addr == "" {
+		addr = GalaxyServeAddr
+	}
+
+	server := newGalaxyServer(ArtifactDir)
+	if err := server.Start(addr); err != nil {
+		return err
+	}
+	defer server.Close()
+
+	artifacts, err := server.artifacts()
+	if err != nil {
+		return err
+	}
+	if len(artifacts) == 0 {
+		pterm.Warning.Println("no archives found, run `mage build` to add one")
+	}
+	for _, artifact := range artifacts {
+		pterm.Info.Printfln("serving %s.%s:%s (%s)", artifact.Namespace, artifact.Name, artifact.Version, artifact.Filename)
+	}
+
+	pterm.Info.Printfln(
+		"install with:\n\tansible-galaxy collection install delinea.core --server %s\n"+
+			"or point `source:` of a requirements.yml entry at it, publish with:\n\tGALAXY_SERVER=%s GALAXY_KEY=local mage publish",
+		server.URL, server.URL,
+	)
+	pterm.Info.Println("press Ctrl+C to stop")
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	pterm.Info.Println("galaxy stand-in stopped")
+	return nil
+}
+
+// galaxyServer is an in-process stand-in for the Galaxy v3 API.
+// Collection archives are stored as plain files in dir, uploads are written there too.
+type galaxyServer struct {
+	URL string
+
+	dir      string
+	mu       sync.Mutex
+	server   *http.Server
+	listener net.Listener
+	imports  int
+}
+
+// galaxyArtifact describes a single collection archive served by the stand-in.
+type galaxyArtifact struct {
+	Namespace    string
+	Name         string
+	Version      string
+	Dependencies map[string]string
+	Filename     string
+	Path         string
+	SHA256       string
+	Size         int64
+}
+
+//nolint:tagliatelle // MANIFEST.json uses snake_case keys.
+type galaxyManifest struct {
+	CollectionInfo struct {
+		Namespace    string            `json:"namespace"`
+		Name         string            `json:"name"`
+		Version      string            `json:"version"`
+		Dependencies map[string]string `json:"dependencies"`
+	} `json:"collection_info"`
+}
+
+//nolint:tagliatelle // Galaxy API uses snake_case keys.
+type galaxyCollection struct {
+	Href           string `json:"href"`
+	Namespace      string `json:"namespace"`
+	Name           string `json:"name"`
+	Deprecated     bool   `json:"deprecated"`
+	VersionsURL    string `json:"versions_url"`
+	HighestVersion struct {
+		Href    string `json:"href"`
+		Version string `json:"version"`
+	} `json:"highest_version"`
+}
+
+type galaxyVersion struct {
+	Href    string `json:"href"`
+	Version string `json:"version"`
+}
+
+//nolint:tagliatelle // Galaxy API uses snake_case keys.
+type galaxyVersionDetail struct {
+	Href        string `json:"href"`
+	Version     string `json:"version"`
+	DownloadURL string `json:"download_url"`
+	Namespace   struct {
+		Name string `json:"name"`
+	} `json:"namespace"`
+	Collection struct {
+		Name string `json:"name"`
+	} `json:"collection"`
+	Artifact struct {
+		Filename string `json:"filename"`
+		SHA256   string `json:"sha256"`
+		Size     int64  `json:"size"`
+	} `json:"artifact"`
+	Metadata struct {
+		Dependencies map[string]string `json:"dependencies"`
+	} `json:"metadata"`
+	Signatures []interface{} `json:"signatures"`
+}
+
+func newGalaxyServer(dir string) *galaxyServer {
+	return &galaxyServer{dir: dir}
+}
+
+// Start listens on addr (e.g. "127.0.0.1:0") and serves the API in the background.
+func (s *galaxyServer) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.URL = "http://" + listener.Addr().String() + "/"
+	s.server = &http.Server{Handler: s, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			pterm.Error.Printfln("galaxy stand-in stopped: %v", err)
+		}
+	}()
+	pterm.Info.Printfln("galaxy stand-in listening on %s (serving %q)", s.URL, s.dir)
+	return nil
+}
+
+// Close stops the server.
+func (s *galaxyServer) Close() error {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+func (s *galaxyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pterm.Debug.Printfln("galaxy stand-in: %s %s", r.Method, r.URL.Path)
+
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "api" && r.Method == http.MethodGet:
+		galaxyRespond(w, http.StatusOK, map[string]interface{}{
+			"available_versions": map[string]string{"v3": "v3/"},
+			"current_version":    "v3",
+		})
+
+	case path == "api/v3/artifacts/collections" && r.Method == http.MethodPost:
+		s.handleUpload(w, r)
+
+	case strings.HasPrefix(path, "api/v3/imports/collections/") && r.Method == http.MethodGet:
+		galaxyRespond(w, http.StatusOK, map[string]interface{}{
+			"id":          parts[len(parts)-1],
+			"state":       "completed",
+			"finished_at": time.Now().UTC().Format(time.RFC3339),
+			"messages":    []interface{}{},
+		})
+
+	case path == "api/v3/collections" && r.Method == http.MethodGet:
+		s.handleCollections(w, r)
+
+	case len(parts) == 5 && strings.HasPrefix(path, "api/v3/collections/") && r.Method == http.MethodGet:
+		s.handleCollection(w, r, parts[3], parts[4])
+
+	case len(parts) == 6 && strings.HasPrefix(path, "api/v3/collections/") && parts[5] == "versions" && r.Method == http.MethodGet:
+		s.handleVersions(w, r, parts[3], parts[4])
+
+	case len(parts) == 7 && strings.HasPrefix(path, "api/v3/collections/") && parts[5] == "versions" && r.Method == http.MethodGet:
+		s.handleVersionDetail(w, r, parts[3], parts[4], parts[6])
+
+	case len(parts) == 2 && parts[0] == "download" && r.Method == http.MethodGet:
+		s.handleDownload(w, r, parts[1])
+
+	default:
+		galaxyError(w, http.StatusNotFound, "not found: %s %s", r.Method, r.URL.Path)
+	}
+}
+
+func (s *galaxyServer) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseMultipartForm(galaxyUploadLimit); err != nil {
+		galaxyError(w, http.StatusBadRequest, "invalid upload: %v", err)
+		return
+	}
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		galaxyError(w, http.StatusBadRequest, "missing file: %v", err)
+		return
+	}
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		galaxyError(w, http.StatusBadRequest, "reading upload: %v", err)
+		return
+	}
+	sum := sha256.Sum256(content)
+	if expected := r.FormValue("sha256"); expected != "" && expected != hex.EncodeToString(sum[:]) {
+		galaxyError(w, http.StatusBadRequest, "sha256 mismatch: expected %s", expected)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Publishing the archive the server already holds (e.g. when serving ArtifactDir) is accepted as a no-op.
+	target := filepath.Join(s.dir, filepath.Base(header.Filename))
+	if existing, err := fileSHA256(target); err == nil {
+		if existing != hex.EncodeToString(sum[:]) {
+			galaxyError(w, http.StatusConflict, "artifact %q already exists", header.Filename)
+			return
+		}
+	} else {
+		if err := os.WriteFile(target, content, 0o600); err != nil {
+			galaxyError(w, http.StatusInternalServerError, "storing upload: %v", err)
+			return
+		}
+		if _, err := galaxyArtifactRead(target); err != nil {
+			_ = os.Remove(target)
+			galaxyError(w, http.StatusBadRequest, "invalid collection archive: %v", err)
+			return
+		}
+	}
+
+	s.imports++
+	galaxyRespond(w, http.StatusAccepted, map[string]string{
+		"task": fmt.Sprintf("/api/v3/imports/collections/%d/", s.imports),
+	})
+}
+
+func (s *galaxyServer) handleCollections(w http.ResponseWriter, r *http.Request) {
+	artifacts, err := s.artifacts()
+	if err != nil {
+		galaxyError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	seen := map[string]bool{}
+	collections := []galaxyCollection{}
+	for _, artifact := range artifacts {
+		key := artifact.Namespace + "." + artifact.Name
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		collection, _ := s.collection(artifacts, artifact.Namespace, artifact.Name)
+		collections = append(collections, collection)
+	}
+	galaxyRespond(w, http.StatusOK, galaxyPage(r, collections, len(collections)))
+}
+
+func (s *galaxyServer) handleCollection(w http.ResponseWriter, _ *http.Request, namespace, name string) {
+	artifacts, err := s.artifacts()
+	if err != nil {
+		galaxyError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	collection, ok := s.collection(artifacts, namespace, name)
+	if !ok {
+		galaxyError(w, http.StatusNotFound, "collection %s.%s not found", namespace, name)
+		return
+	}
+	galaxyRespond(w, http.StatusOK, collection)
+}
+
+func (s *galaxyServer) handleVersions(w http.ResponseWriter, r *http.Request, namespace, name string) {
+	artifacts, err := s.artifacts()
+	if err != nil {
+		galaxyError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+
+	versions := []galaxyVersion{}
+	for _, artifact := range galaxySortVersions(artifacts) {
+		if artifact.Namespace == namespace && artifact.Name == name {
+			versions = append(versions, galaxyVersion{
+				Version: artifact.Version,
+				Href:    s.versionHref(artifact),
+			})
+		}
+	}
+	if len(versions) == 0 {
+		galaxyError(w, http.StatusNotFound, "collection %s.%s not found", namespace, name)
+		return
+	}
+	galaxyRespond(w, http.StatusOK, galaxyPage(r, versions, len(versions)))
+}
+
+func (s *galaxyServer) handleVersionDetail(w http.ResponseWriter, r *http.Request, namespace, name, version string) {
+	artifact, err := s.find(namespace, name, version)
+	if err != nil {
+		galaxyError(w, http.StatusNotFound, "%v", err)
+		return
+	}
+	galaxyRespond(w, http.StatusOK, s.versionDetail(r, artifact))
+}
+
+func (s *galaxyServer) handleDownload(w http.ResponseWriter, r *http.Request, filename string) {
+	artifacts, err := s.artifacts()
+	if err != nil {
+		galaxyError(w, http.StatusInternalServerError, "%v", err)
+		return
+	}
+	for _, artifact := range artifacts {
+		if artifact.Filename == filename {
+			w.Header().Set("Content-Type", "application/gzip")
+			http.ServeFile(w, r, artifact.Path)
+			return
+		}
+	}
+	galaxyError(w, http.StatusNotFound, "artifact %q not found", filename)
+}
+
+func (s *galaxyServer) versionDetail(r *http.Request, artifact galaxyArtifact) galaxyVersionDetail {
+	base := "http://" + r.Host
+
+	detail := galaxyVersionDetail{
+		Href:        s.versionHref(artifact),
+		Version:     artifact.Version,
+		DownloadURL: base + "/download/" + artifact.Filename,
+		Signatures:  []interface{}{},
+	}
+	detail.Namespace.Name = artifact.Namespace
+	detail.Collection.Name = artifact.Name
+	detail.Artifact.Filename = artifact.Filename
+	detail.Artifact.SHA256 = artifact.SHA256
+	detail.Artifact.Size = artifact.Size
+	detail.Metadata.Dependencies = artifact.Dependencies
+	return detail
+}
+
+func (s *galaxyServer) versionHref(artifact galaxyArtifact) string {
+	return fmt.Sprintf("/api/v3/collections/%s/%s/versions/%s/", artifact.Namespace, artifact.Name, artifact.Version)
+}
+
+// collection builds the index entry of a collection, pointing to its highest version.
+func (s *galaxyServer) collection(artifacts []galaxyArtifact, namespace, name string) (galaxyCollection, bool) {
+	matching := []galaxyArtifact{}
+	for _, artifact := range artifacts {
+		if artifact.Namespace == namespace && artifact.Name == name {
+			matching = append(matching, artifact)
+		}
+	}
+	if len(matching) == 0 {
+		return galaxyCollection{}, false
+	}
+	highest := galaxySortVersions(matching)[0]
+
+	collection := galaxyCollection{
+		Href:        fmt.Sprintf("/api/v3/collections/%s/%s/", namespace, name),
+		Namespace:   namespace,
+		Name:        name,
+		VersionsURL: fmt.Sprintf("/api/v3/collections/%s/%s/versions/", namespace, name),
+	}
+	collection.HighestVersion.Href = s.versionHref(highest)
+	collection.HighestVersion.Version = highest.Version
+	return collection, true
+}
+
+func (s *galaxyServer) find(namespace, name, version string) (galaxyArtifact, error) {
+	artifacts, err := s.artifacts()
+	if err != nil {
+		return galaxyArtifact{}, err
+	}
+	for _, artifact := range artifacts {
+		if artifact.Namespace == namespace && artifact.Name == name && artifact.Version == version {
+			return artifact, nil
+		}
+	}
+	return galaxyArtifact{}, fmt.Errorf("collection %s.%s:%s not found", namespace, name, version)
+}
+
+// artifacts scans the directory on every call, so freshly built archives are picked up without a restart.
+func (s *galaxyServer) artifacts() ([]galaxyArtifact, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	artifacts := make([]galaxyArtifact, 0, len(paths))
+	for _, path := range paths {
+		artifact, err := galaxyArtifactRead(path)
+		if err != nil {
+			pterm.Warning.Printfln("galaxy stand-in: skipping %q: %v", path, err)
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// galaxySortVersions returns the artifacts ordered from the highest to the lowest version.
+func galaxySortVersions(artifacts []galaxyArtifact) []galaxyArtifact {
+	sorted := append([]galaxyArtifact{}, artifacts...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		left, errLeft := semver.NewVersion(sorted[i].Version)
+		right, errRight := semver.NewVersion(sorted[j].Version)
+		if errLeft != nil || errRight != nil {
+			return sorted[i].Version > sorted[j].Version
+		}
+		return left.GreaterThan(right)
+	})
+	return sorted
+}
+
+// galaxyPage wraps results in the paginated envelope of the v3 API; the stand-in always returns a single page.
+func galaxyPage(r *http.Request, data interface{}, count int) map[string]interface{} {
+	return map[string]interface{}{
+		"meta": map[string]int{"count": count},
+		"links": map[string]interface{}{
+			"first":    r.URL.RequestURI(),
+			"previous": nil,
+			"next":     nil,
+			"last":     r.URL.RequestURI(),
+		},
+		"data": data,
+	}
+}
+
+func galaxyArtifactRead(path string) (galaxyArtifact, error) {
+	manifest, err := galaxyManifestRead(path)
+	if err != nil {
+		return galaxyArtifact{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return galaxyArtifact{}, err
+	}
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return galaxyArtifact{}, err
+	}
+
+	dependencies := manifest.CollectionInfo.Dependencies
+	if dependencies == nil {
+		dependencies = map[string]string{}
+	}
+	return galaxyArtifact{
+		Namespace:    manifest.CollectionInfo.Namespace,
+		Name:         manifest.CollectionInfo.Name,
+		Version:      manifest.CollectionInfo.Version,
+		Dependencies: dependencies,
+		Filename:     filepath.Base(path),
+		Path:         path,
+		SHA256:       sum,
+		Size:         info.Size(),
+	}, nil
+}
+
+func galaxyManifestRead(path string) (*galaxyManifest, error) {
+	data, err := archiveReadFile(path, "MANIFEST.json")
+	if err != nil {
+		return nil, err
+	}
+	manifest := &galaxyManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("parsing MANIFEST.json: %w", err)
+	}
+	if manifest.CollectionInfo.Namespace == "" || manifest.CollectionInfo.Name == "" || manifest.CollectionInfo.Version == "" {
+		return nil, errors.New("MANIFEST.json misses namespace, name or version")
+	}
+	return manifest, nil
+}
+
+func galaxyRespond(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		pterm.Error.Printfln("galaxy stand-in: writing response: %v", err)
+	}
+}
+
+func galaxyError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	pterm.Debug.Printfln("galaxy stand-in: %d %s", status, message)
+	galaxyRespond(w, status, map[string]interface{}{
+		"errors": []map[string]string{{
+			"status": fmt.Sprint(status),
+			"code":   strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_"),
+			"title":  message,
+			"detail": message,
+		}},
+	})
+}
+
+// ----------------------------------- //
+//        Publish Verification         //
+// ----------------------------------- //
+
+// galaxyFiles is the content of FILES.json stored in every collection archive.
+//
+//nolint:tagliatelle // FILES.json uses snake_case keys.
+type galaxyFiles struct {
+	Files []struct {
+		Name         string `json:"name"`
+		FileType     string `json:"ftype"`
+		ChecksumType string `json:"chksum_type"`
+		Checksum     string `json:"chksum_sha256"`
+	} `json:"files"`
+}
+
+// galaxyVerify fetches the version of the local archive from the server,
+// downloads the published artifact and compares it with the local one.
+func galaxyVerify(server, key, path string) (*releaseRecord, error) {
+	pterm.DefaultSection.Printfln("Verifying `%s` on %s", path, server)
+
+	local, err := galaxyArtifactRead(path)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+
+	v3, err := galaxyDiscover(client, server, key)
+	if err != nil {
+		return nil, err
+	}
+
+	versionURL := v3.ResolveReference(&url.URL{Path: fmt.Sprintf(
+		"collections/%s/%s/versions/%s/", local.Namespace, local.Name, local.Version,
+	)})
+
+	detail := &galaxyVersionDetail{}
+	for attempt := 1; ; attempt++ {
+		err = galaxyGetJSON(client, versionURL.String(), key, detail)
+		if err == nil {
+			break
+		}
+		if attempt == GalaxyVerifyAttempts {
+			return nil, fmt.Errorf("collection version not available on %s: %w", server, err)
+		}
+		pterm.Warning.Printfln("attempt %d/%d: %v", attempt, GalaxyVerifyAttempts, err)
+		time.Sleep(GalaxyVerifyDelay)
+	}
+
+	downloadURL, err := versionURL.Parse(detail.DownloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid download url %q: %w", detail.DownloadURL, err)
+	}
+
+	dir := filepath.Join(CacheDir, "verify")
+	if err := mkdir(dir); err != nil {
+		return nil, err
+	}
+	downloaded := filepath.Join(dir, local.Filename)
+	remoteSum, err := galaxyDownload(client, downloadURL.String(), key, downloaded)
+	if err != nil {
+		return nil, err
+	}
+	pterm.Info.Printfln("downloaded %q", downloadURL)
+
+	mismatches := []string{}
+	if detail.Artifact.SHA256 != "" && detail.Artifact.SHA256 != local.SHA256 {
+		mismatches = append(mismatches, fmt.Sprintf(
+			"server reports sha256 %s, local archive has %s", detail.Artifact.SHA256, local.SHA256,
+		))
+	}
+	if remoteSum != local.SHA256 {
+		mismatches = append(mismatches, fmt.Sprintf(
+			"downloaded archive has sha256 %s, local archive has %s", remoteSum, local.SHA256,
+		))
+	}
+	filesDiff, err := galaxyFilesCompare(path, downloaded)
+	if err != nil {
+		return nil, err
+	}
+	mismatches = append(mismatches, filesDiff...)
+
+	if len(mismatches) > 0 {
+		pterm.Error.Printfln("published collection differs from %q:\n\t- %s", path, strings.Join(mismatches, "\n\t- "))
+		return nil, fmt.Errorf("published collection does not match local archive")
+	}
+	pterm.Success.Printfln("published %s.%s:%s matches local archive (sha256: %s)", local.Namespace, local.Name, local.Version, local.SHA256)
+
+	return &releaseRecord{
+		Namespace:   local.Namespace,
+		Name:        local.Name,
+		Version:     local.Version,
+		Server:      server,
+		Archive:     local.Filename,
+		SHA256:      local.SHA256,
+		Size:        local.Size,
+		DownloadURL: downloadURL.String(),
+		VerifiedAt:  time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
+
+// errGalaxyNotFound is returned by galaxyRequest for 404 responses.
+var errGalaxyNotFound = errors.New("404 Not Found")
+
+// errGalaxyNoAPIRoot is returned by galaxyAPIVersion when the response does not announce API versions,
+// e.g. for the web UI at the root of galaxy.ansible.com.
+var errGalaxyNoAPIRoot = errors.New("not a Galaxy API root")
+
+// galaxyDiscover returns the base URL of the v3 API announced by the server. Like ansible-galaxy, the URL is
+// tried as given first (e.g. `.../api/galaxy/` of Automation Hub), then with `api/` appended if it was not found
+// or is not an API root.
+func galaxyDiscover(client *http.Client, server, key string) (*url.URL, error) {
+	base, err := url.Parse(server)
+	if err != nil {
+		return nil, fmt.Errorf("invalid server url %q: %w", server, err)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+
+	v3, err := galaxyAPIVersion(client, base, key)
+	if err == nil {
+		return v3, nil
+	}
+	if strings.HasSuffix(base.Path, "/api/") || !(errors.Is(err, errGalaxyNotFound) || errors.Is(err, errGalaxyNoAPIRoot)) {
+		return nil, err
+	}
+	api, parseErr := base.Parse("api/")
+	if parseErr != nil {
+		return nil, parseErr
+	}
+	v3, apiErr := galaxyAPIVersion(client, api, key)
+	if errors.Is(apiErr, errGalaxyNotFound) {
+		// Neither exists, the error of the URL as given is the relevant one.
+		return nil, err
+	}
+	return v3, apiErr
+}
+
+// galaxyAPIVersion returns the URL of the v3 API announced by the API root at base.
+func galaxyAPIVersion(client *http.Client, base *url.URL, key string) (*url.URL, error) {
+	//nolint:tagliatelle // Galaxy API uses snake_case keys.
+	root := struct {
+		AvailableVersions map[string]string `json:"available_versions"`
+	}{}
+	err := galaxyGetJSON(client, base.String(), key, &root)
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return nil, fmt.Errorf("%w: %v", errGalaxyNoAPIRoot, err)
+	case err != nil:
+		return nil, err
+	case root.AvailableVersions == nil:
+		return nil, fmt.Errorf("GET %s: %w", base, errGalaxyNoAPIRoot)
+	}
+	v3, ok := root.AvailableVersions["v3"]
+	if !ok {
+		return nil, fmt.Errorf("server %s does not support Galaxy API v3", base)
+	}
+	return base.Parse(v3)
+}
+
+func galaxyRequest(client *http.Client, target, key string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, target, http.NoBody)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" {
+		req.Header.Set("Authorization", "Token "+key)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("GET %s: %w: %s", target, errGalaxyNotFound, bytes.TrimSpace(body))
+		}
+		return nil, fmt.Errorf("GET %s: %s: %s", target, resp.Status, bytes.TrimSpace(body))
+	}
+	return resp, nil
+}
+
+func galaxyGetJSON(client *http.Client, target, key string, v interface{}) error {
+	resp, err := galaxyRequest(client, target, key)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("GET %s: decoding response: %w", target, err)
+	}
+	return nil
+}
+
+// galaxyDownload stores the artifact at path and returns its sha256.
+func galaxyDownload(client *http.Client, target, key, path string) (string, error) {
+	resp, err := galaxyRequest(client, target, key)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, hash), resp.Body); err != nil {
+		return "", fmt.Errorf("downloading %s: %w", target, err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// galaxyFilesCompare reports every file whose FILES.json entry differs between the two archives.
+func galaxyFilesCompare(localPath, remotePath string) ([]string, error) {
+	read := func(path string) (map[string]string, error) {
+		data, err := archiveReadFile(path, "FILES.json")
+		if err != nil {
+			return nil, err
+		}
+		files := &galaxyFiles{}
+		if err := json.Unmarshal(data, files); err != nil {
+			return nil, fmt.Errorf("parsing FILES.json of %q: %w", path, err)
+		}
+		checksums := make(map[string]string, len(files.Files))
+		for _, file := range files.Files {
+			checksums[file.Name] = file.FileType + ":" + file.Checksum
+		}
+		return checksums, nil
+	}
+
+	local, err := read(localPath)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := read(remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := []string{}
+	for name, checksum := range local {
+		remoteChecksum, ok := remote[name]
+		switch {
+		case !ok:
+			diff = append(diff, fmt.Sprintf("FILES.json: %q missing in published archive", name))
+		case remoteChecksum != checksum:
+			diff = append(diff, fmt.Sprintf("FILES.json: %q differs", name))
+		}
+	}
+	for name := range remote {
+		if _, ok := local[name]; !ok {
+			diff = append(diff, fmt.Sprintf("FILES.json: %q only in published archive", name))
+		}
+	}
+	sort.Strings(diff)
+	return diff, nil
+}
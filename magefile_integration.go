@@ -0,0 +1,242 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// E2EDir contains the playbook, scenarios and fixtures of the end-to-end tests.
+	E2EDir = "tests/e2e"
+)
+
+// integrationScenario is a single lookup run against the DSV stand-in.
+//
+//nolint:tagliatelle // Scenario keys match the option names of the lookup plugin.
+type integrationScenario struct {
+	Name    string      `json:"name"`
+	Term    string      `json:"term"`
+	DataKey string      `json:"data_key"`
+	Expect  interface{} `json:"expect"`
+	Fail    string      `json:"fail"`
+	Faults  []dsvFault  `json:"faults"`
+	TLS     bool        `json:"tls"`
+}
+
+// 🔌 TestE2E runs playbooks using the lookup plugin against a local DSV stand-in.
+func TestE2E() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("End-to-end Tests")
+
+	if !venvBinExists("ansible-playbook") {
+		pterm.Error.Println("run `mage init` first")
+		return nil
+	}
+	if err := integrationInstall(); err != nil {
+		return err
+	}
+
+	results, err := integrationSuite(venvPath())
+	if err != nil {
+		return err
+	}
+
+	tbl := pterm.TableData{[]string{"Status", "Scenario", "Took", "Details"}}
+	failed := 0
+	for _, result := range results {
+		status, details := "✅", ""
+		if result.Err != nil {
+			failed++
+			status, details = "❌", result.Err.Error()
+		}
+		tbl = append(tbl, []string{status, result.Name, result.Duration.Round(time.Millisecond).String(), details})
+	}
+
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d end-to-end scenarios failed", failed, len(results))
+	}
+	pterm.Success.Printfln("%d end-to-end scenarios passed", len(results))
+	return nil
+}
+
+// integrationInstall installs the DSV SDK into the virtual environment if missing.
+func integrationInstall() error {
+	if _, err := venvOutput("pip3", "show", "--quiet", SDKPackage); err != nil {
+		return venvInstall(SDKPackage)
+	}
+	return nil
+}
+
+// integrationResult is the outcome of one scenario.
+type integrationResult struct {
+	Name     string
+	Duration time.Duration
+	Err      error
+}
+
+// integrationSuite runs every scenario with the virtual environment against a DSV stand-in started for it.
+func integrationSuite(venv string) ([]integrationResult, error) {
+	fixture, err := dsvFixtureReadDir(filepath.Join(E2EDir, "fixtures"))
+	if err != nil {
+		return nil, err
+	}
+	scenarios := struct {
+		Scenarios []integrationScenario `json:"scenarios"`
+	}{}
+	if err := fixtureRead(filepath.Join(E2EDir, "scenarios.yml"), &scenarios); err != nil {
+		return nil, err
+	}
+
+	root, _, err := collectionLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	server := newDSVServer(fixture)
+	if err := server.Start("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+	defer server.Close()
+	if err := server.StartTLS("127.0.0.1:0"); err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "delinea-core-integration-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	env := map[string]string{
+		"ANSIBLE_COLLECTIONS_PATH": root,
+		"ANSIBLE_NOCOLOR":          "1",
+		"DSV_TENANT":               "mock",
+		"DSV_CLIENT_ID":            fixture.Credentials.ClientID,
+		"DSV_CLIENT_SECRET":        fixture.Credentials.ClientSecret,
+		"DSV_URL_TEMPLATE":         server.URLTemplate(),
+	}
+
+	results := []integrationResult{}
+	for i, scenario := range scenarios.Scenarios {
+		// Faults of the scenario come on top of the faults of the fixture.
+		server.SetFaults(append(append([]dsvFault{}, fixture.Faults...), scenario.Faults...))
+		scenarioEnv := env
+		if scenario.TLS {
+			scenarioEnv = map[string]string{"DSV_URL_TEMPLATE": server.TLSURLTemplate()}
+			for key, value := range env {
+				if key != "DSV_URL_TEMPLATE" {
+					scenarioEnv[key] = value
+				}
+			}
+		}
+
+		now := time.Now()
+		err := integrationRun(venv, scenario, scenarioEnv, filepath.Join(dir, fmt.Sprintf("result-%d", i)))
+		results = append(results, integrationResult{Name: scenario.Name, Duration: time.Since(now), Err: err})
+	}
+	return results, nil
+}
+
+// integrationRun runs the lookup playbook for the scenario and checks the result written to resultFile
+// or, for scenarios expected to fail, the playbook output.
+func integrationRun(venvDir string, scenario integrationScenario, baseEnv map[string]string, resultFile string) error {
+	env := map[string]string{}
+	for key, value := range baseEnv {
+		env[key] = value
+	}
+	if scenario.DataKey != "" {
+		env["DSV_DATA_KEY"] = scenario.DataKey
+	}
+
+	extraVars, err := json.Marshal(map[string]string{"term": scenario.Term, "result_file": resultFile})
+	if err != nil {
+		return err
+	}
+
+	output := &bytes.Buffer{}
+	venv, err := filepath.Abs(venvDir)
+	if err != nil {
+		return err
+	}
+	command, env := venvCommandIn(venv, env, "ansible-playbook")
+	target := testTarget{Venv: venv, Dir: ".", Output: output}
+	runErr := target.runWith(true, env, command,
+		"-i", "localhost,", "-c", "local", filepath.Join(E2EDir, "lookup.yml"), "-e", string(extraVars),
+	)
+	pterm.Debug.Printfln("%s:\n%s", scenario.Name, output)
+
+	if scenario.Fail != "" {
+		if runErr == nil {
+			return fmt.Errorf("expected failure %q, but the play succeeded", scenario.Fail)
+		}
+		if !strings.Contains(output.String(), scenario.Fail) {
+			return fmt.Errorf("expected failure %q not found in output:\n%s", scenario.Fail, integrationTail(output.String()))
+		}
+		return nil
+	}
+
+	if runErr != nil {
+		return fmt.Errorf("%w:\n%s", runErr, integrationTail(output.String()))
+	}
+	data, err := os.ReadFile(resultFile)
+	if err != nil {
+		return err
+	}
+	var actual interface{}
+	if err := json.Unmarshal(data, &actual); err != nil {
+		actual = string(data)
+	}
+	if !integrationMatch(scenario.Expect, actual) {
+		return fmt.Errorf("expected %v, got %s", scenario.Expect, data)
+	}
+	return nil
+}
+
+// integrationMatch reports whether actual contains everything in expected. Maps may have additional keys.
+func integrationMatch(expected, actual interface{}) bool {
+	expectedMap, ok := expected.(map[string]interface{})
+	if !ok {
+		if reflect.DeepEqual(expected, actual) {
+			return true
+		}
+		return fmt.Sprint(expected) == fmt.Sprint(actual)
+	}
+
+	actualMap, ok := actual.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	for key, value := range expectedMap {
+		if !integrationMatch(value, actualMap[key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// integrationTail returns the last lines of the output, where Ansible reports the failure.
+func integrationTail(output string) string {
+	const lines = 10
+	split := strings.Split(strings.TrimSpace(output), "\n")
+	if len(split) > lines {
+		split = split[len(split)-lines:]
+	}
+	return strings.Join(split, "\n")
+}
@@ -0,0 +1,318 @@
+//go:build mage
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+)
+
+const (
+	// JUnitReport is the merged JUnit report in the artifacts directory, with one suite per Ansible version.
+	JUnitReport = "junit.xml"
+
+	// junitMessageLimit shortens failure messages in the summary table.
+	junitMessageLimit = 120
+)
+
+// junitLocation matches "path:line[:column]: message" in failure details, as written by sanity tests and pytest.
+var junitLocation = regexp.MustCompile(`(?m)^\s*([\w./-]+\.\w+):(\d+)(?::\d+)?:\s*(.*)$`)
+
+// ansibleCoreVersion matches the version in the first line of `ansible --version`, e.g. "ansible [core 2.15.3]".
+var ansibleCoreVersion = regexp.MustCompile(`\[core ([^\]]+)\]`)
+
+// junitSuites is the root element of a JUnit report.
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr,omitempty"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     float64      `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     float64     `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	ClassName string       `xml:"classname,attr"`
+	Name      string       `xml:"name,attr"`
+	File      string       `xml:"file,attr,omitempty"`
+	Line      string       `xml:"line,attr,omitempty"`
+	Time      float64      `xml:"time,attr"`
+	Failure   *junitResult `xml:"failure"`
+	Error     *junitResult `xml:"error"`
+	Skipped   *junitResult `xml:"skipped"`
+}
+
+type junitResult struct {
+	Message string `xml:"message,attr,omitempty"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// testReport prints the failures ansible-test reported since the given time if the tests failed,
+// and updates the merged JUnit report with the results of the run (see testRunStart) for the Ansible version
+// in the virtual environment, e.g. with the unit and sanity tests of `mage test`.
+func testReport(target testTarget, since time.Time, testErr error) {
+	if testErr != nil {
+		if err := junitFailuresPrint(target.Output, target.Dir, since); err != nil {
+			pterm.Warning.WithWriter(target.Output).Printfln("reading test results: %v", err)
+		}
+	}
+	version := testAnsibleVersion(target.Venv)
+	if err := junitReportWrite(map[string]string{version: target.Dir}, testRunStarted(target.Dir)); err != nil {
+		pterm.Warning.WithWriter(target.Output).Printfln("writing %s: %v", JUnitReport, err)
+	}
+}
+
+// testAnsibleVersion returns the ansible-core version installed in the virtual environment.
+func testAnsibleVersion(venv string) string {
+	runnable, env := venvCommandIn(venv, nil, "ansible")
+	output, err := sh.OutputWith(env, runnable, "--version")
+	if err != nil {
+		return filepath.Base(venv)
+	}
+	if match := ansibleCoreVersion.FindStringSubmatch(output); match != nil {
+		return "ansible-core " + match[1]
+	}
+	return filepath.Base(venv)
+}
+
+// junitFailuresPrint prints a table of failed tests with their location, if there are any.
+func junitFailuresPrint(w io.Writer, dir string, since time.Time) error {
+	cases, err := junitRead(dir, since)
+	if err != nil {
+		return err
+	}
+	tbl := pterm.TableData{[]string{"Test", "Location", "Message"}}
+	for _, c := range cases {
+		for _, row := range junitFailures(c) {
+			// pytest reports absolute paths, those through the link of collectionLayout are shown in the checkout.
+			row[1] = collectionRepoPath(row[1])
+			tbl = append(tbl, row)
+		}
+	}
+	if len(tbl) == 1 {
+		return nil
+	}
+
+	pterm.DefaultSection.WithWriter(w).Printfln("%d failures:", len(tbl)-1)
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).WithWriter(w).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+	return nil
+}
+
+// junitFailures returns the table rows of a failed test case, one per reported location for sanity tests.
+func junitFailures(c junitCase) [][]string {
+	result := c.Failure
+	if result == nil {
+		result = c.Error
+	}
+	if result == nil {
+		return nil
+	}
+
+	name := c.Name
+	if c.ClassName != "" {
+		name = c.ClassName + "::" + c.Name
+	}
+	message := strings.TrimSpace(strings.SplitN(strings.TrimSpace(result.Message), "\n", 2)[0])
+	matches := junitLocation.FindAllStringSubmatch(result.Text, -1)
+
+	switch {
+	case c.File != "":
+		return [][]string{{name, c.File + ":" + c.Line, junitShorten(message)}}
+
+	case len(matches) > 0 && strings.HasPrefix(c.ClassName, "sanity"):
+		rows := [][]string{}
+		for _, match := range matches {
+			rows = append(rows, []string{name, match[1] + ":" + match[2], junitShorten(match[3])})
+		}
+		return rows
+
+	case len(matches) > 0:
+		// pytest lists the frames of the traceback, the last one raised the error.
+		last := matches[len(matches)-1]
+		if message == "" {
+			message = last[3]
+		}
+		return [][]string{{name, last[1] + ":" + last[2], junitShorten(message)}}
+
+	default:
+		return [][]string{{name, "", junitShorten(message)}}
+	}
+}
+
+func junitShorten(message string) string {
+	if len(message) > junitMessageLimit {
+		return message[:junitMessageLimit-1] + "…"
+	}
+	return message
+}
+
+// junitClear removes the JUnit files of earlier runs below dir.
+func junitClear(dir string) error {
+	return os.RemoveAll(filepath.Join(dir, "tests", "output", "junit"))
+}
+
+// testRuns holds when the run started for each collection directory, mage calls every target once per process.
+var testRuns = struct {
+	sync.Mutex
+	started map[string]time.Time
+}{started: map[string]time.Time{}}
+
+// testRunStart removes the JUnit files of earlier runs below dir on the first call of the run,
+// so the merged report and `mage sanityIgnores` only see results of this run.
+func testRunStart(dir string) error {
+	testRuns.Lock()
+	defer testRuns.Unlock()
+	if _, ok := testRuns.started[dir]; ok {
+		return nil
+	}
+	testRuns.started[dir] = time.Now()
+	return junitClear(dir)
+}
+
+// testRunStarted returns when the run started in dir, the zero time if testRunStart was not called.
+func testRunStarted(dir string) time.Time {
+	testRuns.Lock()
+	defer testRuns.Unlock()
+	return testRuns.started[dir]
+}
+
+// junitRead returns the test cases of the JUnit files ansible-test wrote below dir since the given time.
+func junitRead(dir string, since time.Time) ([]junitCase, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "tests", "output", "junit", "*.xml"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	cases := []junitCase{}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.ModTime().Before(since) {
+			continue
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		suites := junitSuites{}
+		if err := xml.Unmarshal(data, &suites); err != nil {
+			// Some reports have a single suite as root element.
+			suite := junitSuite{}
+			if err := xml.Unmarshal(data, &suite); err != nil {
+				return nil, fmt.Errorf("parsing %q: %w", file, err)
+			}
+			suites.Suites = []junitSuite{suite}
+		}
+		for _, suite := range suites.Suites {
+			cases = append(cases, suite.Cases...)
+		}
+	}
+	return cases, nil
+}
+
+// junitReportWrite updates the merged report in the artifacts directory with a suite per Ansible version,
+// built from the JUnit files written since the given time in the collection directory of each version.
+// Suites of other versions are kept.
+func junitReportWrite(dirs map[string]string, since time.Time) error {
+	path := filepath.Join(ArtifactDir, JUnitReport)
+	report := junitSuites{Name: "delinea.core"}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := xml.Unmarshal(data, &report); err != nil {
+			pterm.Warning.Printfln("replacing invalid %q: %v", path, err)
+			report = junitSuites{Name: "delinea.core"}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	for version, dir := range dirs {
+		cases, err := junitRead(dir, since)
+		if err != nil {
+			return err
+		}
+		suite := junitSuite{Name: version, Cases: cases}
+		replaced := false
+		for i := range report.Suites {
+			if report.Suites[i].Name == version {
+				report.Suites[i], replaced = suite, true
+			}
+		}
+		if !replaced {
+			report.Suites = append(report.Suites, suite)
+		}
+	}
+	sort.Slice(report.Suites, func(i, j int) bool { return report.Suites[i].Name < report.Suites[j].Name })
+
+	report.Tests, report.Failures, report.Errors, report.Skipped, report.Time = 0, 0, 0, 0, 0
+	for i := range report.Suites {
+		suite := &report.Suites[i]
+		suite.Tests, suite.Failures, suite.Errors, suite.Skipped, suite.Time = len(suite.Cases), 0, 0, 0, 0
+		for _, c := range suite.Cases {
+			switch {
+			case c.Failure != nil:
+				suite.Failures++
+			case c.Error != nil:
+				suite.Errors++
+			case c.Skipped != nil:
+				suite.Skipped++
+			}
+			suite.Time += c.Time
+		}
+		suite.Time = math.Round(suite.Time*1000) / 1000
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Errors += suite.Errors
+		report.Skipped += suite.Skipped
+		report.Time += suite.Time
+	}
+
+	report.Time = math.Round(report.Time*1000) / 1000
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := mkdir(ArtifactDir); err != nil {
+		return err
+	}
+	const permBits = 0o644
+	if err := os.WriteFile(path, append([]byte(xml.Header), append(data, '\n')...), permBits); err != nil {
+		return err
+	}
+	pterm.Debug.Printfln("updated %q", path)
+	return nil
+}
@@ -0,0 +1,326 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// AnsibleVersions lists the Ansible versions tested in CI, used by `mage testMatrix all`.
+	AnsibleVersions = "stable-2.14 stable-2.15 stable-2.16 devel"
+
+	// MatrixParallel is the default number of Ansible versions tested at once, override with `TEST_MATRIX_PARALLEL`.
+	MatrixParallel = 2
+)
+
+// matrixResult is the outcome of testing one Ansible version with one Python version.
+type matrixResult struct {
+	Version  string
+	Python   pythonInterpreter
+	Init     string
+	Units    string
+	Sanity   string
+	Duration time.Duration
+	Log      string
+	Dir      string
+	Err      error
+}
+
+// 🧮 TestMatrix runs unit and sanity tests for several Ansible versions, each with its own virtual environment.
+// Versions are separated by commas or spaces, "all" tests every version tested in CI.
+// Set `TEST_MATRIX_PYTHONS` to Python versions or paths to test each with ("all" for every interpreter found),
+// combinations Ansible does not support are skipped.
+func TestMatrix(versions string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("ansible-test matrix")
+
+	list := strings.FieldsFunc(versions, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(list) == 0 || (len(list) == 1 && list[0] == "all") {
+		list = strings.Fields(AnsibleVersions)
+	}
+
+	parallel := MatrixParallel
+	if value := os.Getenv("TEST_MATRIX_PARALLEL"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return fmt.Errorf("TEST_MATRIX_PARALLEL must be a positive number, got %q", value)
+		}
+		parallel = n
+	}
+
+	pythons, err := matrixPythons(os.Getenv("TEST_MATRIX_PYTHONS"))
+	if err != nil {
+		return err
+	}
+
+	mode, reason, err := testModeDetect()
+	if err != nil {
+		return err
+	}
+	pterm.Info.Printfln("execution mode %q: %s", mode, reason)
+
+	files, err := sh.Output("git", "ls-files", "--cached", "--others", "--exclude-standard")
+	if err != nil {
+		return fmt.Errorf("listing collection files: %w", err)
+	}
+
+	logDir := filepath.Join(ArtifactDir, "matrix")
+	if err := mkdir(logDir); err != nil {
+		return err
+	}
+
+	minors := []string{}
+	for _, python := range pythons {
+		minors = append(minors, python.Minor())
+	}
+	pterm.Info.Printfln("testing %s with Python %s (%d at once), logs in %q",
+		strings.Join(list, ", "), strings.Join(minors, ", "), parallel, logDir)
+
+	now := time.Now()
+	results := []matrixResult{}
+	for _, version := range list {
+		for _, python := range pythons {
+			results = append(results, matrixResult{Version: version, Python: python})
+		}
+	}
+	semaphore := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+	for i := range results {
+		name := results[i].Name()
+		if _, err := pythonSelect(results[i].Python.Path, results[i].Version); err != nil {
+			pterm.Warning.Printfln("%s: skipped, %v", name, err)
+			results[i].Init, results[i].Units, results[i].Sanity = "➖", "➖", "➖"
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			results[i] = matrixRun(results[i].Version, results[i].Python, mode, strings.Split(files, "\n"), logDir)
+			if results[i].Err != nil {
+				pterm.Error.Printfln("%s: %v", name, results[i].Err)
+			} else {
+				pterm.Success.Printfln("%s (took: %s)", name, results[i].Duration.Round(time.Second))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	tbl := pterm.TableData{[]string{"Ansible", "Python", "Init", "Units", "Sanity", "Duration", "Log"}}
+	failed, tested := 0, 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+		duration, python := "", result.Python.Minor()
+		if result.Log != "" {
+			tested++
+			duration = result.Duration.Round(time.Second).String()
+		} else {
+			python += " (unsupported)"
+		}
+		tbl = append(tbl, []string{
+			result.Version, python, result.Init, result.Units, result.Sanity, duration, result.Log,
+		})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+
+	dirs := map[string]string{}
+	for _, result := range results {
+		if result.Dir == "" {
+			continue
+		}
+		dirs[result.Name()] = result.Dir
+		if result.Err != nil {
+			pterm.DefaultSection.Println(result.Name())
+			if err := junitFailuresPrint(os.Stdout, result.Dir, time.Time{}); err != nil {
+				pterm.Warning.Printfln("reading test results: %v", err)
+			}
+		}
+	}
+	if err := junitReportWrite(dirs, now); err != nil {
+		pterm.Warning.Printfln("writing %s: %v", JUnitReport, err)
+	}
+
+	if tested == 0 {
+		return fmt.Errorf("no supported combination of Ansible and Python versions to test, see `mage pythons`")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d Ansible and Python versions failed", failed, tested)
+	}
+	return nil
+}
+
+// Name identifies the combination in logs, directories and reports, e.g. "stable-2.15-py3.11".
+func (r matrixResult) Name() string { return r.Version + "-py" + r.Python.Minor() }
+
+// matrixPythons returns the interpreters given by TEST_MATRIX_PYTHONS, `python3` from PATH if empty.
+func matrixPythons(value string) ([]pythonInterpreter, error) {
+	specs := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(specs) == 1 && specs[0] == "all" {
+		interpreters := pythonDiscover()
+		if len(interpreters) == 0 {
+			return nil, fmt.Errorf("no Python interpreters found")
+		}
+		// Several interpreters of the same minor version would test the same thing.
+		seen := map[string]bool{}
+		pythons := []pythonInterpreter{}
+		for _, interpreter := range interpreters {
+			if !seen[interpreter.Minor()] {
+				seen[interpreter.Minor()] = true
+				pythons = append(pythons, interpreter)
+			}
+		}
+		return pythons, nil
+	}
+	if len(specs) == 0 {
+		specs = []string{""}
+	}
+	pythons := []pythonInterpreter{}
+	for _, spec := range specs {
+		python, err := pythonResolve(spec)
+		if err != nil {
+			return nil, fmt.Errorf("TEST_MATRIX_PYTHONS: %w", err)
+		}
+		pythons = append(pythons, python)
+	}
+	return pythons, nil
+}
+
+// matrixRun initializes the virtual environment of the version if missing and runs the tests
+// in a private copy of the collection, so parallel runs don't share `tests/output`.
+func matrixRun(version string, python pythonInterpreter, mode string, files []string, logDir string) (result matrixResult) {
+	const (
+		passed  = "✅"
+		failed  = "❌"
+		skipped = "➖"
+	)
+
+	now := time.Now()
+	result = matrixResult{Version: version, Python: python, Init: skipped, Units: skipped, Sanity: skipped}
+	result.Log = filepath.Join(logDir, result.Name()+".log")
+	defer func() { result.Duration = time.Since(now) }()
+
+	log, err := os.Create(result.Log)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer log.Close()
+
+	venv := venvKeyed(version, python)
+	if !venvBinExistsIn(venv, "ansible-test") {
+		if err := matrixInit(venv, python, version); err != nil {
+			result.Init, result.Err = failed, err
+			return result
+		}
+		result.Init = passed
+	}
+
+	namespace, name, err := collectionName()
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Dir = filepath.Join(CacheDir, "matrix", result.Name(), "ansible_collections", namespace, name)
+	if err := matrixCopy(files, result.Dir); err != nil {
+		result.Err = err
+		return result
+	}
+
+	target := testTarget{Venv: venv, Dir: result.Dir, Output: log, Mode: mode}
+	if err := target.resolve(); err != nil {
+		result.Err = err
+		return result
+	}
+	// The copy is kept between runs, the report is built from both steps of this run.
+	if err := junitClear(result.Dir); err != nil {
+		result.Err = err
+		return result
+	}
+	if err := testUnits(target); err != nil {
+		result.Units, result.Err = failed, err
+	} else {
+		result.Units = passed
+	}
+	if err := testSanity(target); err != nil {
+		result.Sanity = failed
+		if result.Err == nil {
+			result.Err = err
+		}
+	} else {
+		result.Sanity = passed
+	}
+	return result
+}
+
+func matrixInit(venv string, python pythonInterpreter, version string) error {
+	link := fmt.Sprintf("https://github.com/ansible/ansible/archive/%s.tar.gz", version)
+	if err := venvCreate(venv, python.Path, false); err != nil {
+		return err
+	}
+	if err := venvInstallIn(venv, "wheel"); err != nil {
+		return err
+	}
+	return venvInstallIn(venv, link)
+}
+
+// matrixCopy replaces dir with a fresh copy of the given files.
+func matrixCopy(files []string, dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file == "" || strings.HasPrefix(file, CacheDir+"/") || strings.HasPrefix(file, ArtifactDir+"/") {
+			continue
+		}
+		info, err := os.Lstat(file)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		target := filepath.Join(dir, file)
+		if err := mkdir(filepath.Dir(target)); err != nil {
+			return err
+		}
+		if err := matrixCopyFile(file, target, info.Mode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func matrixCopyFile(source, target string, mode os.FileMode) error {
+	r, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, mode.Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
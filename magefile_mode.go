@@ -0,0 +1,121 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+)
+
+const (
+	// TestModeDocker runs ansible-test in its default Docker containers.
+	TestModeDocker = "docker"
+
+	// TestModePodman runs ansible-test containers with Podman.
+	TestModePodman = "podman"
+
+	// TestModeVenv lets ansible-test create its own virtual environments, no container runtime needed.
+	TestModeVenv = "venv"
+
+	// TestModeLocal runs ansible-test directly in the virtual environment of the project.
+	TestModeLocal = "local"
+)
+
+// resolve selects the execution mode and the Python version of the target, unless already set.
+//
+// The mode comes from `TEST_MODE` or, when unset, from the first available container runtime,
+// falling back to "venv". The Python version comes from `TEST_PYTHON` or the target's virtual environment,
+// so every mode tests with the same interpreter version.
+func (t *testTarget) resolve() error {
+	if t.Mode == "" {
+		mode, reason, err := testModeDetect()
+		if err != nil {
+			return err
+		}
+		t.Mode = mode
+		pterm.Info.Printfln("execution mode %q: %s", mode, reason)
+	}
+
+	if t.Python == "" {
+		python, reason, err := testPythonDetect(t.Venv)
+		if err != nil {
+			return err
+		}
+		t.Python = python
+		pterm.Info.Printfln("python %s: %s", python, reason)
+	}
+	return nil
+}
+
+// modeArgs returns the ansible-test arguments selecting the execution environment.
+func (t testTarget) modeArgs() []string {
+	args := []string{}
+	switch t.Mode {
+	case TestModeDocker, TestModePodman:
+		args = append(args, "--docker")
+	case TestModeVenv:
+		args = append(args, "--venv")
+	case TestModeLocal:
+		args = append(args, "--local")
+	}
+	if t.Python != "" {
+		args = append(args, "--python", t.Python)
+	}
+	return args
+}
+
+// testModeDetect returns the execution mode and a human readable reason for choosing it.
+func testModeDetect() (string, string, error) {
+	if mode := strings.ToLower(strings.TrimSpace(os.Getenv("TEST_MODE"))); mode != "" {
+		switch mode {
+		case TestModeDocker, TestModePodman:
+			if !testRuntimeAvailable(mode) {
+				return "", "", fmt.Errorf("TEST_MODE=%s, but %s is not available", mode, mode)
+			}
+			return mode, "selected by TEST_MODE", nil
+		case TestModeVenv, TestModeLocal:
+			return mode, "selected by TEST_MODE", nil
+		default:
+			return "", "", fmt.Errorf(
+				"unknown TEST_MODE %q, expected one of: %s, %s, %s, %s",
+				mode, TestModeDocker, TestModePodman, TestModeVenv, TestModeLocal,
+			)
+		}
+	}
+
+	if testRuntimeAvailable(TestModeDocker) {
+		return TestModeDocker, "docker is available (set TEST_MODE to override)", nil
+	}
+	if testRuntimeAvailable(TestModePodman) {
+		return TestModePodman, "docker is not available, podman is (set TEST_MODE to override)", nil
+	}
+	return TestModeVenv, "neither docker nor podman is available (set TEST_MODE to override)", nil
+}
+
+// testRuntimeAvailable reports whether the container runtime is installed and can reach its daemon or socket.
+func testRuntimeAvailable(runtime string) bool {
+	if _, err := sh.Output(runtime, "info"); err != nil {
+		pterm.Debug.Printfln("%s info: %v", runtime, err)
+		return false
+	}
+	return true
+}
+
+// testPythonDetect returns the Python version (e.g. "3.10") to test with and the reason for it.
+func testPythonDetect(venv string) (string, string, error) {
+	if python := strings.TrimSpace(os.Getenv("TEST_PYTHON")); python != "" {
+		return python, "selected by TEST_PYTHON", nil
+	}
+	version, err := sh.Output(
+		filepath.Join(venv, "bin", "python3"), "-c", `import sys; print("%d.%d" % sys.version_info[:2])`,
+	)
+	if err != nil {
+		return "", "", fmt.Errorf("detecting python version of %q: %w", venv, err)
+	}
+	return strings.TrimSpace(version), fmt.Sprintf("version of %q (set TEST_PYTHON to override)", venv), nil
+}
@@ -0,0 +1,251 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+// ansiblePythons lists the controller Python versions supported by each ansible-core release,
+// from the ansible-core support matrix. Lookup plugins run on the controller.
+var ansiblePythons = map[string][2]string{
+	"2.13": {"3.8", "3.10"},
+	"2.14": {"3.9", "3.11"},
+	"2.15": {"3.9", "3.11"},
+	"2.16": {"3.10", "3.12"},
+	"2.17": {"3.10", "3.12"},
+	"2.18": {"3.11", "3.13"},
+}
+
+// pythonInterpreter is a Python found on this machine.
+type pythonInterpreter struct {
+	Path    string
+	Version string // e.g. "3.11.7"
+	Source  string
+}
+
+// Minor returns the version without patch level, e.g. "3.11".
+func (p pythonInterpreter) Minor() string {
+	parts := strings.SplitN(p.Version, ".", 3)
+	if len(parts) < 2 {
+		return p.Version
+	}
+	return parts[0] + "." + parts[1]
+}
+
+// 🐍 Pythons lists the Python interpreters found locally (PATH, pyenv, uv) and the Ansible versions supporting them.
+// Select one with `PYTHON` (version or path) for `mage init`, `mage initCI` and `mage testMatrix`.
+func Pythons() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Python Interpreters")
+
+	interpreters := pythonDiscover()
+	if len(interpreters) == 0 {
+		pterm.Warning.Println("no Python interpreters found")
+		return nil
+	}
+
+	releases := make([]string, 0, len(ansiblePythons))
+	for release := range ansiblePythons {
+		releases = append(releases, release)
+	}
+	sort.Slice(releases, func(i, j int) bool { return versionLess(releases[i], releases[j]) })
+
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	tbl := pterm.TableData{[]string{"Version", "Path", "Source", "ansible-core"}}
+	for _, interpreter := range interpreters {
+		supported := []string{}
+		for _, release := range releases {
+			if pythonSupported(release, interpreter.Minor()) {
+				supported = append(supported, release)
+			}
+		}
+		tbl = append(tbl, []string{interpreter.Version, interpreter.Path, interpreter.Source, strings.Join(supported, ", ")})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+	return nil
+}
+
+// pythonSelect returns the interpreter to create a virtual environment for the Ansible version with:
+// the one given by spec (a version like "3.11" or a path) or `python3` from PATH if spec is empty.
+// Combinations Ansible does not support are rejected.
+func pythonSelect(spec, ansibleVersion string) (pythonInterpreter, error) {
+	interpreter, err := pythonResolve(spec)
+	if err != nil {
+		return interpreter, err
+	}
+	release, err := ansibleRelease(ansibleVersion)
+	if err != nil {
+		return interpreter, err
+	}
+	if _, known := ansiblePythons[release]; !known {
+		pterm.Warning.Printfln("no Python support data for ansible-core %s, using Python %s", release, interpreter.Version)
+		return interpreter, nil
+	}
+	if !pythonSupported(release, interpreter.Minor()) {
+		supported := ansiblePythons[release]
+		return interpreter, fmt.Errorf("ansible-core %s (%s) supports Python %s to %s, not %s (%s), see `mage pythons`",
+			release, ansibleVersion, supported[0], supported[1], interpreter.Version, interpreter.Path)
+	}
+	return interpreter, nil
+}
+
+// pythonResolve finds the interpreter for a version like "3.11" or a path, `python3` from PATH if spec is empty.
+func pythonResolve(spec string) (pythonInterpreter, error) {
+	switch {
+	case spec == "":
+		path, err := exec.LookPath("python3")
+		if err != nil {
+			return pythonInterpreter{}, fmt.Errorf("python3 not found in PATH, set PYTHON to an interpreter")
+		}
+		return pythonInspect(path, "PATH")
+
+	case strings.ContainsRune(spec, filepath.Separator):
+		return pythonInspect(spec, "PYTHON")
+	}
+
+	for _, interpreter := range pythonDiscover() {
+		if interpreter.Minor() == spec || interpreter.Version == spec {
+			return interpreter, nil
+		}
+	}
+	return pythonInterpreter{}, fmt.Errorf("no Python %s found, see `mage pythons` for the interpreters available", spec)
+}
+
+// pythonDiscover finds interpreters in PATH (python3, python3.X), pyenv and uv, without duplicates.
+func pythonDiscover() []pythonInterpreter {
+	type candidate struct{ path, source string }
+	candidates := []candidate{}
+
+	for _, name := range []string{"python3"} {
+		if path, err := exec.LookPath(name); err == nil {
+			candidates = append(candidates, candidate{path, "PATH"})
+		}
+	}
+	for minor := 6; minor <= 14; minor++ {
+		if path, err := exec.LookPath(fmt.Sprintf("python3.%d", minor)); err == nil {
+			candidates = append(candidates, candidate{path, "PATH"})
+		}
+	}
+
+	pyenvRoot := os.Getenv("PYENV_ROOT")
+	if output, err := sh.Output("pyenv", "root"); err == nil {
+		pyenvRoot = strings.TrimSpace(output)
+	}
+	if pyenvRoot != "" {
+		paths, _ := filepath.Glob(filepath.Join(pyenvRoot, "versions", "*", "bin", "python3"))
+		for _, path := range paths {
+			candidates = append(candidates, candidate{path, "pyenv"})
+		}
+	}
+
+	// uv lists one interpreter per line: "cpython-3.12.1-linux-x86_64-gnu    /path/to/python3.12".
+	if output, err := sh.Output("uv", "python", "list", "--only-installed"); err == nil {
+		for _, line := range strings.Split(output, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && strings.HasPrefix(fields[1], "/") {
+				candidates = append(candidates, candidate{fields[1], "uv"})
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	interpreters := []pythonInterpreter{}
+	for _, c := range candidates {
+		real, err := filepath.EvalSymlinks(c.path)
+		if err != nil || seen[real] {
+			continue
+		}
+		seen[real] = true
+		interpreter, err := pythonInspect(c.path, c.source)
+		if err != nil {
+			pterm.Debug.Printfln("skipping %s: %v", c.path, err)
+			continue
+		}
+		interpreters = append(interpreters, interpreter)
+	}
+	sort.SliceStable(interpreters, func(i, j int) bool {
+		return versionLess(interpreters[i].Version, interpreters[j].Version)
+	})
+	return interpreters
+}
+
+// pythonInspect runs the interpreter to get its version. Its stderr is discarded, as pyenv shims
+// of versions not selected complain there.
+func pythonInspect(path, source string) (pythonInterpreter, error) {
+	output, err := exec.Command(path, "-c", `import sys; print("%d.%d.%d" % sys.version_info[:3])`).Output()
+	version := string(output)
+	if err != nil {
+		return pythonInterpreter{}, fmt.Errorf("running %q: %w", path, err)
+	}
+	if !strings.HasPrefix(version, "3.") {
+		return pythonInterpreter{}, fmt.Errorf("%q is Python %s, Python 3 is required", path, version)
+	}
+	return pythonInterpreter{Path: path, Version: strings.TrimSpace(version), Source: source}, nil
+}
+
+// pythonSupported reports whether ansible-core release supports the Python version (e.g. "3.11") on the controller.
+func pythonSupported(release, python string) bool {
+	supported, ok := ansiblePythons[release]
+	if !ok {
+		return true
+	}
+	return !versionLess(python, supported[0]) && !versionLess(supported[1], python)
+}
+
+// ansibleRelease returns the ansible-core release of a version as used by the targets, e.g. "2.15" for
+// "stable-2.15" or "v2.15.3". "devel" is the release after the latest stable one in AnsibleVersions.
+func ansibleRelease(version string) (string, error) {
+	if version == "devel" {
+		latest := 0
+		for _, candidate := range strings.Fields(AnsibleVersions) {
+			if minor, err := strconv.Atoi(strings.TrimPrefix(candidate, "stable-2.")); err == nil && minor > latest {
+				latest = minor
+			}
+		}
+		return fmt.Sprintf("2.%d", latest+1), nil
+	}
+
+	version = strings.TrimPrefix(strings.TrimPrefix(version, "stable-"), "v")
+	parts := strings.Split(version, ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("unexpected Ansible version %q, expected e.g. stable-2.15, v2.15.3 or devel", version)
+	}
+	for _, part := range parts[:2] {
+		if _, err := strconv.Atoi(part); err != nil {
+			return "", fmt.Errorf("unexpected Ansible version %q, expected e.g. stable-2.15, v2.15.3 or devel", version)
+		}
+	}
+	return parts[0] + "." + parts[1], nil
+}
+
+// versionLess compares dotted version numbers numerically, e.g. "3.9" < "3.10".
+func versionLess(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.Atoi(as[i])
+		y, _ := strconv.Atoi(bs[i])
+		if x != y {
+			return x < y
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// venvKeyed returns the virtual environment for an Ansible and Python version, e.g. `.cache/venvs/stable-2.15-py3.11`.
+func venvKeyed(ansibleVersion string, python pythonInterpreter) string {
+	return filepath.Join(CacheDir, "venvs", ansibleVersion+"-py"+python.Minor())
+}
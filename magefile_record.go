@@ -0,0 +1,262 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// DSVRecordDir is the directory below the fixtures directory `mage dsv:record` writes to.
+	// Recorded secrets replace hand-written ones of the same path.
+	DSVRecordDir = "recorded"
+
+	// DSVRecordFile is the fixture file written by `mage dsv:record` into DSVRecordDir.
+	DSVRecordFile = "recorded.json"
+
+	// dsvRecordClientID and dsvRecordClientSecret replace the real credentials in recorded fixtures.
+	dsvRecordClientID     = "recorded-client-id"
+	dsvRecordClientSecret = "recorded-client-secret"
+
+	// dsvRecordRedacted replaces recorded secret values and tokens.
+	dsvRecordRedacted = "redacted"
+
+	// dsvRecordBodyLimit is the maximum size of a request or response passing through the proxy.
+	dsvRecordBodyLimit = 1 << 20
+)
+
+// 📼 Record proxies the DSV API at upstream (e.g. "https://tenant.secretsvaultcloud.com/v1") and saves token
+// and secret responses as fixture in `<dir>/recorded`, with credentials, secret values, attributes and descriptions
+// replaced by placeholders.
+func (Dsv) Record(upstream, dir string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("DSV recording proxy")
+
+	if !strings.HasPrefix(upstream, "https://") && !strings.HasPrefix(upstream, "http://") {
+		return fmt.Errorf("upstream must be the URL of the DSV API, e.g. https://tenant.secretsvaultcloud.com/v1, got %q", upstream)
+	}
+	if err := mkdir(filepath.Join(dir, DSVRecordDir)); err != nil {
+		return err
+	}
+	addr := os.Getenv("DSV_MOCK_ADDR")
+	if addr == "" {
+		addr = DSVMockAddr
+	}
+
+	recorder, err := newDSVRecorder(strings.TrimSuffix(upstream, "/"), filepath.Join(dir, DSVRecordDir, DSVRecordFile))
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{Handler: recorder, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			pterm.Error.Printfln("dsv recording proxy stopped: %v", err)
+		}
+	}()
+	defer server.Close()
+
+	pterm.Info.Printfln(
+		"forwarding to %s, recording into %q, keep the real DSV_CLIENT_ID and DSV_CLIENT_SECRET and export:\n"+
+			"\texport DSV_URL_TEMPLATE=http://%s/v1",
+		recorder.Upstream, recorder.File, listener.Addr(),
+	)
+	pterm.Info.Printfln("replay with:\n\tmage dsv:mock %s", dir)
+	pterm.Info.Println("press Ctrl+C to stop")
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	<-interrupt
+	pterm.Info.Println("dsv recording proxy stopped")
+	return nil
+}
+
+// dsvRecorder forwards requests to the DSV API and adds successful token and secret responses to a fixture file.
+type dsvRecorder struct {
+	Upstream string
+	File     string
+	Client   *http.Client
+
+	mu      sync.Mutex
+	fixture *dsvFixture
+}
+
+// newDSVRecorder continues the fixture in file if it exists.
+func newDSVRecorder(upstream, file string) (*dsvRecorder, error) {
+	fixture := &dsvFixture{Secrets: map[string]map[string]interface{}{}}
+	if _, err := os.Stat(file); err == nil {
+		if fixture, err = dsvFixtureRead(file); err != nil {
+			return nil, err
+		}
+	}
+	fixture.Credentials.ClientID = dsvRecordClientID
+	fixture.Credentials.ClientSecret = dsvRecordClientSecret
+
+	return &dsvRecorder{
+		Upstream: upstream,
+		File:     file,
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		fixture:  fixture,
+	}, nil
+}
+
+func (d *dsvRecorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status, header, body, err := d.forward(r)
+	if err != nil {
+		pterm.Error.Printfln("dsv recording proxy: %s %s: %v", r.Method, r.URL.Path, err)
+		dsvError(w, http.StatusBadGateway, "forwarding to %s: %v", d.Upstream, err)
+		return
+	}
+	pterm.Info.Printfln("dsv recording proxy: %s %s %d", r.Method, r.URL.Path, status)
+
+	for _, key := range []string{"Content-Type", "Retry-After"} {
+		if value := header.Get(key); value != "" {
+			w.Header().Set(key, value)
+		}
+	}
+	w.WriteHeader(status)
+	if _, err := w.Write(body); err != nil {
+		pterm.Error.Printfln("dsv recording proxy: writing response: %v", err)
+	}
+
+	if status != http.StatusOK {
+		return
+	}
+	if err := d.record(r.URL.Path, body); err != nil {
+		pterm.Warning.Printfln("not recorded %s: %v", r.URL.Path, err)
+	}
+}
+
+// forward sends the request to the upstream API, keeping the path below `/v1`.
+func (d *dsvRecorder) forward(r *http.Request) (int, http.Header, []byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, dsvRecordBodyLimit))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	target := d.Upstream + strings.TrimPrefix(r.URL.Path, "/v1")
+	if r.URL.RawQuery != "" {
+		target += "?" + r.URL.RawQuery
+	}
+
+	request, err := http.NewRequestWithContext(r.Context(), r.Method, target, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	for _, key := range []string{"Authorization", "Content-Type", "Accept", "User-Agent"} {
+		if value := r.Header.Get(key); value != "" {
+			request.Header.Set(key, value)
+		}
+	}
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(io.LimitReader(response.Body, dsvRecordBodyLimit))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	return response.StatusCode, response.Header, responseBody, nil
+}
+
+// record adds a token or secret response to the fixture and saves it.
+func (d *dsvRecorder) record(path string, body []byte) error {
+	response := map[string]interface{}{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case path == "/v1/token":
+		token := map[string]interface{}{}
+		for key, value := range response {
+			switch {
+			case key == "accessToken":
+				// The stand-in issues its own access tokens.
+			case key != "tokenType" && strings.Contains(strings.ToLower(key), "token"):
+				token[key] = dsvRecordRedacted
+			default:
+				token[key] = value
+			}
+		}
+		d.fixture.Token = token
+		pterm.Success.Println("recorded token response")
+
+	case strings.HasPrefix(path, "/v1/secrets/"):
+		secretPath := dsvPath(strings.TrimPrefix(path, "/v1/secrets/"))
+		for _, key := range []string{"createdBy", "lastModifiedBy"} {
+			if _, ok := response[key]; ok {
+				response[key] = "users:" + dsvRecordRedacted
+			}
+		}
+		// Attributes and descriptions often name tenant internals, like the values of data.
+		response["data"] = dsvRedact(response["data"])
+		if _, ok := response["attributes"]; ok {
+			response["attributes"] = dsvRedact(response["attributes"])
+		}
+		if _, ok := response["description"]; ok {
+			response["description"] = dsvRecordRedacted
+		}
+		d.fixture.Secrets[secretPath] = response
+		pterm.Success.Printfln("recorded secret %q", secretPath)
+
+	default:
+		return nil
+	}
+
+	data, err := json.MarshalIndent(d.fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	const permBits = 0o644
+	return os.WriteFile(d.File, append(data, '\n'), permBits)
+}
+
+// dsvRedact replaces the values in secret data by placeholders of the same type, keeping keys and structure.
+func dsvRedact(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		redacted := map[string]interface{}{}
+		for key, item := range value {
+			redacted[key] = dsvRedact(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(value))
+		for i, item := range value {
+			redacted[i] = dsvRedact(item)
+		}
+		return redacted
+	case string:
+		return dsvRecordRedacted
+	case float64:
+		return 0
+	default:
+		return value
+	}
+}
@@ -0,0 +1,904 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// ReleaseSigningKeyEnv holds a base64 encoded ed25519 seed used to sign release records.
+	// When unset, a key is generated once and kept in the cache directory.
+	ReleaseSigningKeyEnv = "RELEASE_SIGNING_KEY"
+
+	// ReleaseSigningPublicKeyEnv holds the base64 encoded ed25519 public key release records are verified with.
+	// It takes precedence over ReleaseSigningPublicKeyFile.
+	ReleaseSigningPublicKeyEnv = "RELEASE_SIGNING_PUBLIC_KEY"
+
+	// ReleaseSigningPublicKeyFile is the committed public key of the maintainers' signing key.
+	ReleaseSigningPublicKeyFile = ".github/release-signing.pub"
+
+	// ReleaseWebhooksEnv holds a comma separated list of webhooks notified after publishing.
+	// Entries are `slack=<url>`, `teams=<url>`, `generic=<url>` or a bare URL for the generic JSON format.
+	ReleaseWebhooksEnv = "RELEASE_WEBHOOKS"
+
+	// ReleaseWebhooksDryRunEnv prints the notification payloads instead of sending them when set to "true".
+	ReleaseWebhooksDryRunEnv = "RELEASE_WEBHOOKS_DRY_RUN"
+
+	// ReleaseNotifyAttempts is how many times a webhook delivery is tried.
+	ReleaseNotifyAttempts = 4
+
+	// ReleaseNotifyBackoff is the delay before the first retry, doubled after every attempt.
+	ReleaseNotifyBackoff = 2 * time.Second
+
+	// ReleaseNotifyRetryAfterMax caps the delay a webhook asks for with `Retry-After`.
+	ReleaseNotifyRetryAfterMax = time.Minute
+
+	// ReleaseLedgerFile is the append-only release audit log in the artifact directory.
+	ReleaseLedgerFile = "release-ledger.jsonl"
+
+	// ReleaseLedgerFileEnv names a tracked file the ledger is additionally appended to, e.g. ".github/release-ledger.jsonl".
+	ReleaseLedgerFileEnv = "RELEASE_LEDGER_FILE"
+
+	// releaseSigningAlgorithm is the algorithm recorded next to every signature.
+	releaseSigningAlgorithm = "ed25519"
+)
+
+// errReleaseNoTrustedKey is returned by releaseTrustedKey when no public key is configured.
+var errReleaseNoTrustedKey = errors.New("no trusted signing key")
+
+// releaseRecord describes a published and verified collection version.
+type releaseRecord struct {
+	Namespace   string            `json:"namespace"`
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	Server      string            `json:"server"`
+	Archive     string            `json:"archive"`
+	SHA256      string            `json:"sha256"`
+	Size        int64             `json:"size"`
+	DownloadURL string            `json:"download-url"`
+	VerifiedAt  string            `json:"verified-at"`
+	Signature   *releaseSignature `json:"signature,omitempty"`
+}
+
+type releaseSignature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public-key"`
+	Value     string `json:"value"`
+}
+
+// releaseRecordPath returns the location of the record for the given version inside dir.
+func releaseRecordPath(dir string, record *releaseRecord) string {
+	return filepath.Join(dir, fmt.Sprintf("release-%s-%s-%s.json", record.Namespace, record.Name, record.Version))
+}
+
+// releaseRecordWrite signs the record and stores it in dir.
+func releaseRecordWrite(dir string, record *releaseRecord) error {
+	key, err := releaseSigningKey()
+	if err != nil {
+		return err
+	}
+
+	record.Signature = nil
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	record.Signature = &releaseSignature{
+		Algorithm: releaseSigningAlgorithm,
+		PublicKey: base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+		Value:     base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	}
+
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := mkdir(dir); err != nil {
+		return err
+	}
+	path := releaseRecordPath(dir, record)
+	if err := writeFile(path, string(data)+"\n"); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("release record written to %q", path)
+	return nil
+}
+
+// releaseSigningCheck fails when the signing key is not the trusted one, records signed with it could not be verified.
+// Without trusted key, it only warns.
+func releaseSigningCheck() error {
+	key, err := releaseSigningKey()
+	if err != nil {
+		return err
+	}
+	trusted, err := releaseTrustedKey()
+	if errors.Is(err, errReleaseNoTrustedKey) {
+		pterm.Warning.Printfln("the release record cannot be verified: %v", err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !trusted.Equal(key.Public()) {
+		return fmt.Errorf("the signing key does not match the trusted public key, check %s", ReleaseSigningKeyEnv)
+	}
+	return nil
+}
+
+// releaseRecordCheck validates the signature of a stored release record with the trusted public key.
+// The key embedded in the record must be the trusted one, anyone could re-sign an edited record with their own.
+func releaseRecordCheck(path string, trusted ed25519.PublicKey) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	record := &releaseRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return fmt.Errorf("parsing %q: %w", path, err)
+	}
+	signature := record.Signature
+	if signature == nil || signature.Algorithm != releaseSigningAlgorithm {
+		return fmt.Errorf("release record %q is not signed with %s", path, releaseSigningAlgorithm)
+	}
+	publicKey, err := base64.StdEncoding.DecodeString(signature.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("release record %q has an invalid public key", path)
+	}
+	if !trusted.Equal(ed25519.PublicKey(publicKey)) {
+		return fmt.Errorf("release record %q is signed with the untrusted key %s", path, signature.PublicKey)
+	}
+	value, err := base64.StdEncoding.DecodeString(signature.Value)
+	if err != nil {
+		return fmt.Errorf("release record %q has an invalid signature encoding", path)
+	}
+
+	record.Signature = nil
+	payload, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(trusted, payload, value) {
+		return fmt.Errorf("release record %q has an invalid signature", path)
+	}
+	pterm.Success.Printfln("release record %q has a valid signature", path)
+	return nil
+}
+
+// 🔏 VerifyRecord checks the signature of a release record (e.g. `.artifacts/release-delinea-core-1.0.0.json`)
+// with the trusted public key.
+func VerifyRecord(path string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	trusted, err := releaseTrustedKey()
+	if err != nil {
+		return err
+	}
+	return releaseRecordCheck(path, trusted)
+}
+
+// releaseTrustedKey returns the public key from `RELEASE_SIGNING_PUBLIC_KEY` or ReleaseSigningPublicKeyFile.
+func releaseTrustedKey() (ed25519.PublicKey, error) {
+	encoded, source := os.Getenv(ReleaseSigningPublicKeyEnv), ReleaseSigningPublicKeyEnv
+	if encoded == "" {
+		data, err := os.ReadFile(ReleaseSigningPublicKeyFile)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("%w, set %s or commit %q (see `mage releasePublicKey`)",
+				errReleaseNoTrustedKey, ReleaseSigningPublicKeyEnv, ReleaseSigningPublicKeyFile)
+		}
+		if err != nil {
+			return nil, err
+		}
+		encoded, source = string(data), ReleaseSigningPublicKeyFile
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s must be a base64 encoded %d byte ed25519 public key", source, ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// 🔑 ReleasePublicKey prints the public key of the release signing key, to commit as
+// `.github/release-signing.pub` or set as `RELEASE_SIGNING_PUBLIC_KEY` where records are verified.
+func ReleasePublicKey() error {
+	key, err := releaseSigningKey()
+	if err != nil {
+		return err
+	}
+	fmt.Println(base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	return nil
+}
+
+func releaseSigningKey() (ed25519.PrivateKey, error) {
+	encoded := os.Getenv(ReleaseSigningKeyEnv)
+	if encoded == "" {
+		path := filepath.Join(CacheDir, "release-signing.key")
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			encoded = string(data)
+
+		case errors.Is(err, os.ErrNotExist):
+			seed := make([]byte, ed25519.SeedSize)
+			if _, err := rand.Read(seed); err != nil {
+				return nil, err
+			}
+			encoded = base64.StdEncoding.EncodeToString(seed)
+			if err := mkdir(CacheDir); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(path, []byte(encoded), 0o600); err != nil {
+				return nil, err
+			}
+			pterm.Warning.Printfln("%s is not set, generated a local signing key %q", ReleaseSigningKeyEnv, path)
+
+		default:
+			return nil, err
+		}
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s must be a base64 encoded %d byte ed25519 seed", ReleaseSigningKeyEnv, ed25519.SeedSize)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ----------------------------------- //
+//        Release Notifications        //
+// ----------------------------------- //
+
+// releaseWebhook is a single notification target configured in `RELEASE_WEBHOOKS`.
+type releaseWebhook struct {
+	Format string
+	URL    string
+}
+
+// releaseNotification is the content announced after a release.
+type releaseNotification struct {
+	Collection string `json:"collection"`
+	Version    string `json:"version"`
+	Server     string `json:"server"`
+	SHA256     string `json:"sha256"`
+	Summary    string `json:"summary"`
+}
+
+// releaseNotifier posts release notifications to webhooks, retrying failed deliveries with exponential backoff.
+type releaseNotifier struct {
+	Client   *http.Client
+	Attempts int
+	Backoff  time.Duration
+	DryRun   bool
+}
+
+// 📣 Notify announces the built archive as released on `GALAXY_SERVER` to the webhooks in `RELEASE_WEBHOOKS`.
+func Notify() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Release notification")
+
+	path, err := archiveFind("delinea-core*.tar.gz")
+	if err != nil {
+		pterm.Error.Println("run `mage build` first")
+		return err
+	}
+	artifact, err := galaxyArtifactRead(path)
+	if err != nil {
+		return err
+	}
+	return releaseNotify(&releaseRecord{
+		Namespace: artifact.Namespace,
+		Name:      artifact.Name,
+		Version:   artifact.Version,
+		Server:    os.Getenv("GALAXY_SERVER"),
+		SHA256:    artifact.SHA256,
+	})
+}
+
+// 🧪 TestNotify sends a notification in every format to a local webhook receiver and checks the payloads,
+// the retries of failed deliveries and that `Retry-After` is honored.
+func TestNotify() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Release notification to local receiver")
+
+	type delivery struct {
+		Time time.Time
+		Body map[string]interface{}
+	}
+	var mu sync.Mutex
+	deliveries := map[string][]delivery{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		deliveries[r.URL.Path] = append(deliveries[r.URL.Path], delivery{Time: time.Now(), Body: body})
+		count := len(deliveries[r.URL.Path])
+		mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/rate-limited" && count == 1:
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case r.URL.Path == "/unavailable" && count < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/rejected":
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	notification := releaseNotification{
+		Collection: "delinea.core", Version: "1.2.3", Server: "https://galaxy.example", SHA256: "0123abcd", Summary: "Test release.",
+	}
+	// A short backoff shows whether the one second of `Retry-After` was waited for.
+	notifier := &releaseNotifier{Client: server.Client(), Attempts: ReleaseNotifyAttempts, Backoff: 10 * time.Millisecond}
+	webhooks := []releaseWebhook{
+		{Format: "slack", URL: server.URL + "/slack"},
+		{Format: "teams", URL: server.URL + "/teams"},
+		{Format: "generic", URL: server.URL + "/generic"},
+		{Format: "generic", URL: server.URL + "/rate-limited"},
+		{Format: "generic", URL: server.URL + "/unavailable"},
+	}
+	if err := notifier.Send(webhooks, notification); err != nil {
+		return err
+	}
+	if err := notifier.Send([]releaseWebhook{{Format: "generic", URL: server.URL + "/rejected"}}, notification); err == nil {
+		return errors.New("rejected delivery was reported as success")
+	}
+
+	title := "delinea.core 1.2.3 released"
+	failures := []string{}
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			failures = append(failures, fmt.Sprintf(format, args...))
+		}
+	}
+	text := func(path, key string) string {
+		if len(deliveries[path]) == 0 {
+			return ""
+		}
+		value, _ := deliveries[path][0].Body[key].(string)
+		return value
+	}
+	check(strings.Contains(text("/slack", "text"), "*"+title+"*"), "slack payload without title: %v", deliveries["/slack"])
+	check(strings.Contains(text("/slack", "text"), "`0123abcd`"), "slack payload without sha256: %v", deliveries["/slack"])
+	check(text("/teams", "@type") == "MessageCard" && text("/teams", "title") == title,
+		"teams payload is not a MessageCard with title: %v", deliveries["/teams"])
+	check(text("/generic", "version") == "1.2.3" && text("/generic", "summary") == "Test release.",
+		"generic payload without version and summary: %v", deliveries["/generic"])
+
+	for path, attempts := range map[string]int{
+		"/slack": 1, "/teams": 1, "/generic": 1, "/rate-limited": 2, "/unavailable": 3, "/rejected": 1,
+	} {
+		check(len(deliveries[path]) == attempts, "%s: expected %d attempts, got %d", path, attempts, len(deliveries[path]))
+	}
+	if limited := deliveries["/rate-limited"]; len(limited) == 2 {
+		wait := limited[1].Time.Sub(limited[0].Time)
+		check(wait >= time.Second, "/rate-limited: retried after %s, Retry-After asked for 1s", wait)
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("release notification checks failed:\n\t%s", strings.Join(failures, "\n\t"))
+	}
+	pterm.Success.Println("slack, teams and generic payloads, retries and Retry-After passed")
+	return nil
+}
+
+// releaseNotify sends the notification for the record if any webhooks are configured.
+func releaseNotify(record *releaseRecord) error {
+	webhooks, err := releaseWebhooks(os.Getenv(ReleaseWebhooksEnv))
+	if err != nil {
+		return err
+	}
+	if len(webhooks) == 0 {
+		pterm.Debug.Printfln("%s is not set, skipping release notification", ReleaseWebhooksEnv)
+		return nil
+	}
+
+	summary, err := releaseSummary(record.Version)
+	if err != nil {
+		pterm.Warning.Printfln("release summary for %q not found: %v", record.Version, err)
+	}
+
+	notifier := &releaseNotifier{
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		Attempts: ReleaseNotifyAttempts,
+		Backoff:  ReleaseNotifyBackoff,
+		DryRun:   os.Getenv(ReleaseWebhooksDryRunEnv) == "true",
+	}
+	return notifier.Send(webhooks, releaseNotification{
+		Collection: record.Namespace + "." + record.Name,
+		Version:    record.Version,
+		Server:     record.Server,
+		SHA256:     record.SHA256,
+		Summary:    summary,
+	})
+}
+
+// releaseWebhooks parses a comma separated list of `format=url` entries.
+// Entries without a format are sent as generic JSON.
+func releaseWebhooks(value string) ([]releaseWebhook, error) {
+	webhooks := []releaseWebhook{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		webhook := releaseWebhook{Format: "generic", URL: entry}
+		if format, target, ok := strings.Cut(entry, "="); ok && !strings.Contains(format, "://") {
+			webhook = releaseWebhook{Format: format, URL: target}
+		}
+		switch webhook.Format {
+		case "generic", "slack", "teams":
+		default:
+			return nil, fmt.Errorf("unknown webhook format %q, expected one of: generic, slack, teams", webhook.Format)
+		}
+		if _, err := url.ParseRequestURI(webhook.URL); err != nil {
+			return nil, fmt.Errorf("invalid webhook url %q: %w", webhook.URL, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// releaseSummary returns the release summary of the version from the changelog.
+func releaseSummary(version string) (string, error) {
+	summary, err := sh.Output(
+		"yq", "-r", fmt.Sprintf(`.releases["%s"].changes.release_summary // ""`, version),
+		filepath.Join("changelogs", "changelog.yaml"),
+	)
+	if err != nil {
+		return "", err
+	}
+	summary = strings.TrimSpace(summary)
+	if summary == "" {
+		return "", fmt.Errorf("no release_summary for version %q", version)
+	}
+	return summary, nil
+}
+
+// Send delivers the notification to every webhook and reports all failed deliveries.
+func (n *releaseNotifier) Send(webhooks []releaseWebhook, notification releaseNotification) error {
+	failed := []string{}
+	for _, webhook := range webhooks {
+		payload, err := json.Marshal(releasePayload(webhook.Format, notification))
+		if err != nil {
+			return err
+		}
+
+		if n.DryRun {
+			pterm.Info.Printfln("dry-run: %s webhook %s:\n%s", webhook.Format, releaseRedactURL(webhook.URL), payload)
+			continue
+		}
+
+		if err := n.post(webhook.URL, payload); err != nil {
+			pterm.Error.Printfln("%s webhook %s: %v", webhook.Format, releaseRedactURL(webhook.URL), err)
+			failed = append(failed, releaseRedactURL(webhook.URL))
+			continue
+		}
+		pterm.Success.Printfln("notified %s webhook %s", webhook.Format, releaseRedactURL(webhook.URL))
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("release notification failed for: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+func (n *releaseNotifier) post(target string, payload []byte) error {
+	delay := n.Backoff
+	var err error
+	for attempt := 1; attempt <= n.Attempts; attempt++ {
+		var retry bool
+		var retryAfter time.Duration
+		retryAfter, retry, err = n.postOnce(target, payload)
+		if err == nil || !retry {
+			return err
+		}
+		if attempt < n.Attempts {
+			// The delay the webhook asks for takes precedence over the backoff.
+			wait := delay
+			if retryAfter > 0 {
+				wait = retryAfter
+			}
+			pterm.Warning.Printfln("attempt %d/%d: %v, retrying in %s", attempt, n.Attempts, err, wait)
+			time.Sleep(wait)
+			delay *= 2
+		}
+	}
+	return err
+}
+
+// postOnce sends the payload and reports whether a failure is worth retrying,
+// and how long to wait before if the webhook sent `Retry-After`.
+func (n *releaseNotifier) postOnce(target string, payload []byte) (time.Duration, bool, error) {
+	resp, err := n.Client.Post(target, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return 0, false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return releaseRetryAfter(resp.Header.Get("Retry-After")), true, fmt.Errorf("unexpected response: %s", resp.Status)
+	default:
+		return 0, false, fmt.Errorf("unexpected response: %s", resp.Status)
+	}
+}
+
+// releaseRetryAfter parses a `Retry-After` header, given in seconds or as HTTP date, capped at ReleaseNotifyRetryAfterMax.
+func releaseRetryAfter(value string) time.Duration {
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	}
+	switch {
+	case delay < 0:
+		return 0
+	case delay > ReleaseNotifyRetryAfterMax:
+		return ReleaseNotifyRetryAfterMax
+	default:
+		return delay
+	}
+}
+
+// releasePayload renders the notification in the format expected by the webhook.
+func releasePayload(format string, notification releaseNotification) interface{} {
+	title := fmt.Sprintf("%s %s released", notification.Collection, notification.Version)
+
+	switch format {
+	case "slack":
+		text := fmt.Sprintf(
+			"*%s*\n%s\n• Server: %s\n• sha256: `%s`",
+			title, notification.Summary, notification.Server, notification.SHA256,
+		)
+		return map[string]interface{}{
+			"text": text,
+			"blocks": []interface{}{
+				map[string]interface{}{
+					"type": "section",
+					"text": map[string]string{"type": "mrkdwn", "text": text},
+				},
+			},
+		}
+
+	case "teams":
+		return map[string]interface{}{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  title,
+			"title":    title,
+			"text":     notification.Summary,
+			"sections": []interface{}{
+				map[string]interface{}{
+					"facts": []map[string]string{
+						{"name": "Version", "value": notification.Version},
+						{"name": "Server", "value": notification.Server},
+						{"name": "sha256", "value": notification.SHA256},
+					},
+				},
+			},
+		}
+
+	default:
+		return notification
+	}
+}
+
+// releaseRedactURL hides the path of webhook URLs, which usually contains the secret token.
+func releaseRedactURL(target string) string {
+	parsed, err := url.Parse(target)
+	if err != nil {
+		return "<invalid url>"
+	}
+	return parsed.Scheme + "://" + parsed.Host + "/*****"
+}
+
+// ----------------------------------- //
+//          Release Audit Log          //
+// ----------------------------------- //
+
+// ledgerEntry is a single line of the release ledger.
+// Every entry includes the hash of the previous one, so removing or editing lines breaks the chain.
+type ledgerEntry struct {
+	Time     string `json:"time"`
+	Action   string `json:"action"`
+	GitUser  string `json:"git-user"`
+	Commit   string `json:"commit"`
+	Version  string `json:"version"`
+	Archive  string `json:"archive,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Server   string `json:"server,omitempty"`
+	Duration string `json:"duration"`
+	Outcome  string `json:"outcome"`
+	Error    string `json:"error,omitempty"`
+	Previous string `json:"previous"`
+	Hash     string `json:"hash"`
+}
+
+// ledgerHead anchors the hash chain: it is signed with the configured release signing key, so removing entries
+// at the end of the ledger, or rewriting it, is detected without the key.
+type ledgerHead struct {
+	Entries   int    `json:"entries"`
+	Hash      string `json:"hash"`
+	Signature string `json:"signature"`
+}
+
+// ledgerPath returns the release ledger shown by ReleaseLog: the tracked copy if `RELEASE_LEDGER_FILE` is set,
+// the one in the artifact directory otherwise.
+func ledgerPath() string {
+	if path := os.Getenv(ReleaseLedgerFileEnv); path != "" {
+		return path
+	}
+	return filepath.Join(ArtifactDir, ReleaseLedgerFile)
+}
+
+// ledgerPaths returns the ledgers every entry is appended to: the one in the artifact directory
+// and the tracked copy if `RELEASE_LEDGER_FILE` is set.
+func ledgerPaths() []string {
+	paths := []string{filepath.Join(ArtifactDir, ReleaseLedgerFile)}
+	if path := os.Getenv(ReleaseLedgerFileEnv); path != "" {
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+// ledgerHeadPath returns the file of the signed head next to the ledger.
+func ledgerHeadPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".head.json"
+}
+
+// 📒 ReleaseLog shows ledger entries of the given version ("all" for every entry) and verifies the hash chain.
+func ReleaseLog(version string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Release Log")
+
+	path := ledgerPath()
+	entries, err := ledgerRead(path)
+	if err != nil {
+		return fmt.Errorf("%q: %w", path, err)
+	}
+
+	pterm.DefaultSection.Printfln("%s (%d entries)", path, len(entries))
+
+	tbl := pterm.TableData{
+		[]string{"Time", "Action", "Version", "Outcome", "Duration", "Git User", "Commit", "sha256", "Server"},
+	}
+	for _, entry := range entries {
+		if version != "all" && version != "" && entry.Version != version {
+			continue
+		}
+		commit, sum := entry.Commit, entry.SHA256
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		if len(sum) > 12 {
+			sum = sum[:12]
+		}
+		tbl = append(tbl, []string{
+			entry.Time, entry.Action, entry.Version, entry.Outcome, entry.Duration, entry.GitUser, commit, sum, entry.Server,
+		})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+
+	if err := ledgerVerify(entries); err != nil {
+		return fmt.Errorf("%q: hash chain is broken: %w", path, err)
+	}
+	pterm.Success.Printfln("%q: hash chain is valid", path)
+
+	if _, err := os.Stat(ledgerHeadPath(path)); errors.Is(err, os.ErrNotExist) {
+		pterm.Warning.Printfln("%q has no signed head, set %s when releasing to detect removed entries",
+			path, ReleaseSigningKeyEnv)
+		return nil
+	}
+	trusted, err := releaseTrustedKey()
+	if err != nil {
+		return fmt.Errorf("verifying the head of %q: %w", path, err)
+	}
+	if err := ledgerHeadVerify(ledgerHeadPath(path), entries, trusted); err != nil {
+		return fmt.Errorf("%q: %w", ledgerHeadPath(path), err)
+	}
+	pterm.Success.Printfln("%q: head is signed with the trusted key", ledgerHeadPath(path))
+	return nil
+}
+
+// ledgerAppend records the outcome of a release target; deferred at the top of the target.
+// Failing to write the ledger is reported, but does not change the outcome of the target.
+func ledgerAppend(action string, start time.Time, errp *error) {
+	entry := ledgerEntry{
+		Time:     start.UTC().Format(time.RFC3339),
+		Action:   action,
+		Duration: time.Since(start).Round(time.Millisecond).String(),
+		Outcome:  "success",
+	}
+	if errp != nil && *errp != nil {
+		entry.Outcome = "failure"
+		entry.Error = (*errp).Error()
+	}
+
+	name, _ := sh.Output("git", "config", "user.name")
+	email, _ := sh.Output("git", "config", "user.email")
+	entry.GitUser = strings.TrimSpace(fmt.Sprintf("%s <%s>", name, email))
+	entry.Commit, _ = sh.Output("git", "rev-parse", "HEAD")
+	version, _ := sh.Output("yq", "-r", ".version", "galaxy.yml")
+	entry.Version = strings.TrimSpace(version)
+
+	if action == "build" || action == "publish" {
+		if path, err := archiveFind("delinea-core*.tar.gz"); err == nil {
+			entry.Archive = filepath.Base(path)
+			entry.SHA256, _ = fileSHA256(path)
+		}
+	}
+	if action == "publish" {
+		entry.Server = os.Getenv("GALAXY_SERVER")
+	}
+
+	for _, path := range ledgerPaths() {
+		if err := ledgerWrite(path, entry); err != nil {
+			pterm.Error.Printfln("failed to append to release ledger %q: %v", path, err)
+		}
+	}
+}
+
+func ledgerWrite(path string, entry ledgerEntry) error {
+	entries, err := ledgerRead(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(entries) > 0 {
+		entry.Previous = entries[len(entries)-1].Hash
+	}
+	entry.Hash, err = ledgerHash(entry)
+	if err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := mkdir(filepath.Dir(path)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return ledgerHeadWrite(ledgerHeadPath(path), len(entries)+1, entry.Hash)
+}
+
+// ledgerHeadWrite signs the number of entries and the hash of the last one with the release signing key.
+// Without `RELEASE_SIGNING_KEY` the head is not written: a key generated per machine anchors nothing.
+func ledgerHeadWrite(path string, entries int, hash string) error {
+	if os.Getenv(ReleaseSigningKeyEnv) == "" {
+		pterm.Debug.Printfln("%s is not set, not signing the head of the release ledger", ReleaseSigningKeyEnv)
+		return nil
+	}
+	key, err := releaseSigningKey()
+	if err != nil {
+		return err
+	}
+	head := ledgerHead{
+		Entries:   entries,
+		Hash:      hash,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte(fmt.Sprintf("%d:%s", entries, hash)))),
+	}
+	data, err := json.MarshalIndent(head, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(path, string(data)+"\n")
+}
+
+// ledgerHeadVerify checks that the head matches the last entry and is signed with the trusted key.
+func ledgerHeadVerify(path string, entries []ledgerEntry, trusted ed25519.PublicKey) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	head := ledgerHead{}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return err
+	}
+	signature, err := base64.StdEncoding.DecodeString(head.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid signature encoding")
+	}
+	if !ed25519.Verify(trusted, []byte(fmt.Sprintf("%d:%s", head.Entries, head.Hash)), signature) {
+		return fmt.Errorf("head is not signed with the trusted key")
+	}
+	last := ""
+	if len(entries) > 0 {
+		last = entries[len(entries)-1].Hash
+	}
+	if head.Entries != len(entries) || head.Hash != last {
+		return fmt.Errorf("head covers %d entries, the ledger has %d, entries were removed or added without the key",
+			head.Entries, len(entries))
+	}
+	return nil
+}
+
+func ledgerRead(path string) ([]ledgerEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries := []ledgerEntry{}
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry := ledgerEntry{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// ledgerHash returns the sha256 of the entry without its own hash.
+func ledgerHash(entry ledgerEntry) (string, error) {
+	entry.Hash = ""
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func ledgerVerify(entries []ledgerEntry) error {
+	previous := ""
+	for i, entry := range entries {
+		if entry.Previous != previous {
+			return fmt.Errorf("entry %d does not follow entry %d", i+1, i)
+		}
+		hash, err := ledgerHash(entry)
+		if err != nil {
+			return err
+		}
+		if hash != entry.Hash {
+			return fmt.Errorf("entry %d was modified", i+1)
+		}
+		previous = entry.Hash
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+//go:build mage
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// SanityIgnoresFile is the source of the per-version ignore files and the paths excluded from sanity tests.
+	SanityIgnoresFile = "tests/sanity/ignores.yml"
+)
+
+// sanityTests maps the sanity tests of ansible-test to the error codes they report.
+// Every test accepts "skip" (written as `test!skip`) and a Python version suffix (e.g. `compile-3.10`).
+// The validate-modules codes are the common ones, others only warn as ansible-test adds new ones.
+var sanityTests = map[string]*regexp.Regexp{
+	"action-plugin-docs":        regexp.MustCompile(`^$`),
+	"ansible-doc":               regexp.MustCompile(`^$`),
+	"changelog":                 regexp.MustCompile(`^$`),
+	"compile":                   regexp.MustCompile(`^$`),
+	"empty-init":                regexp.MustCompile(`^$`),
+	"future-import-boilerplate": regexp.MustCompile(`^$`),
+	"ignores":                   regexp.MustCompile(`^$`),
+	"import":                    regexp.MustCompile(`^(unwanted-module|import-error)?$`),
+	"line-endings":              regexp.MustCompile(`^$`),
+	"metaclass-boilerplate":     regexp.MustCompile(`^$`),
+	"mypy":                      regexp.MustCompile(`^[a-z][a-z0-9-]*$`),
+	"no-assert":                 regexp.MustCompile(`^$`),
+	"no-basestring":             regexp.MustCompile(`^$`),
+	"no-dict-iteritems":         regexp.MustCompile(`^$`),
+	"no-dict-iterkeys":          regexp.MustCompile(`^$`),
+	"no-dict-itervalues":        regexp.MustCompile(`^$`),
+	"no-get-exception":          regexp.MustCompile(`^$`),
+	"no-illegal-filenames":      regexp.MustCompile(`^$`),
+	"no-main-display":           regexp.MustCompile(`^$`),
+	"no-smart-quotes":           regexp.MustCompile(`^$`),
+	"no-unicode-literals":       regexp.MustCompile(`^$`),
+	"pep8":                      regexp.MustCompile(`^[EW]\d{3}$`),
+	"pslint":                    regexp.MustCompile(`^[A-Za-z]+$`),
+	"pylint":                    regexp.MustCompile(`^[a-z][a-z0-9-]*$`),
+	"replace-urlopen":           regexp.MustCompile(`^$`),
+	"runtime-metadata":          regexp.MustCompile(`^$`),
+	"shebang":                   regexp.MustCompile(`^$`),
+	"shellcheck":                regexp.MustCompile(`^SC\d{4}$`),
+	"symlinks":                  regexp.MustCompile(`^$`),
+	"use-argspec-type-path":     regexp.MustCompile(`^$`),
+	"use-compat-six":            regexp.MustCompile(`^$`),
+	"validate-modules": regexp.MustCompile(`^(` + strings.Join([]string{
+		"bad-return-value-key", "collection-deprecated-version", "deprecation-mismatch", "doc-choices-do-not-match-spec",
+		"doc-default-does-not-match-spec", "doc-default-incompatible-type", "doc-elements-invalid",
+		"doc-elements-mismatch", "doc-missing-type", "doc-required-mismatch", "doc-type-does-not-match-spec",
+		"import-before-documentation", "invalid-ansiblemodule-schema", "invalid-argument-name",
+		"invalid-documentation", "invalid-examples", "invalid-extension", "invalid-metadata-status",
+		"invalid-requires-extension", "last-line-main-call", "missing-documentation", "missing-examples",
+		"missing-gplv3-license", "missing-if-name-main", "missing-main-call", "missing-module-utils-basic-import",
+		"missing-python-interpreter", "missing-return", "module-invalid-version-added", "mutually_exclusive-unknown",
+		"no-default-for-required-parameter", "nonexistent-parameter-documented", "option-incorrect-version-added",
+		"option-invalid-version-added", "parameter-invalid", "parameter-list-no-elements", "parameter-type-not-in-doc",
+		"required_if-unknown", "return-syntax-error", "undocumented-parameter", "use-run-command-not-popen",
+	}, "|") + `)$`),
+	"yamllint": regexp.MustCompile(`^(unparsable-with-libyaml|[a-z][a-z0-9-]*)?$`),
+}
+
+// sanityTestPython splits a test name with Python version suffix, e.g. "import-3.11".
+var sanityTestPython = regexp.MustCompile(`^(.+)-(\d+\.\d+)$`)
+
+// sanityCodeFormat is the format of error codes not known by sanityTests, e.g. new ones of validate-modules.
+var sanityCodeFormat = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// sanityIgnores is the content of the source file.
+type sanityIgnores struct {
+	// Exclude lists paths not tested at all, passed to ansible-test as `--exclude`.
+	Exclude []string `json:"exclude"`
+
+	Ignores []sanityIgnore `json:"ignores"`
+}
+
+// sanityIgnore is an entry of the ignore files, limited to some Ansible versions (e.g. "2.14") if Versions is set.
+type sanityIgnore struct {
+	Path     string   `json:"path"`
+	Test     string   `json:"test"`
+	Code     string   `json:"code"`
+	Versions []string `json:"versions"`
+	Reason   string   `json:"reason"`
+}
+
+// Line returns the entry as written to the ignore file of ansible-test.
+func (i sanityIgnore) Line() string {
+	line := i.Path + " " + i.Test
+	switch i.Code {
+	case "":
+	case "skip":
+		line += "!skip"
+	default:
+		line += ":" + i.Code
+	}
+	if i.Reason != "" {
+		line += " # " + i.Reason
+	}
+	return line
+}
+
+// 🙈 SanityIgnores writes `tests/sanity/ignore-<version>.txt` for every tested Ansible version from
+// `tests/sanity/ignores.yml`, validates the entries and reports ignores the last sanity run found unnecessary.
+func SanityIgnores() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Sanity Ignores")
+
+	source, err := sanityIgnoresRead(".")
+	if err != nil {
+		return err
+	}
+
+	problems := []string{}
+	for _, ignore := range source.Ignores {
+		invalid, warnings := sanityIgnoreValidate(ignore)
+		problems = append(problems, invalid...)
+		for _, warning := range warnings {
+			pterm.Warning.Println(warning)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid entries in %q:\n\t%s", SanityIgnoresFile, strings.Join(problems, "\n\t"))
+	}
+
+	versions, err := sanityVersions()
+	if err != nil {
+		return err
+	}
+	for _, version := range versions {
+		path := filepath.Join("tests", "sanity", "ignore-"+version+".txt")
+		lines := []string{}
+		for _, ignore := range source.Ignores {
+			if len(ignore.Versions) == 0 || sanityVersionMatch(ignore.Versions, version) {
+				lines = append(lines, ignore.Line())
+			}
+		}
+		sort.Strings(lines)
+
+		content := strings.Join(lines, "\n")
+		if content != "" {
+			content += "\n"
+		}
+		if current, err := os.ReadFile(path); err == nil && string(current) == content {
+			pterm.Info.Printfln("%s is up to date (%d entries)", path, len(lines))
+			continue
+		}
+		const permBits = 0o644
+		if err := os.WriteFile(path, []byte(content), permBits); err != nil {
+			return err
+		}
+		pterm.Success.Printfln("wrote %s (%d entries)", path, len(lines))
+	}
+
+	stale, err := sanityIgnoresStale(".")
+	if err != nil {
+		return err
+	}
+	if len(stale) > 0 {
+		pterm.Warning.Printfln("the last sanity run found these ignores unnecessary, remove them from %q:\n\t%s",
+			SanityIgnoresFile, strings.Join(stale, "\n\t"))
+	}
+	return nil
+}
+
+// sanityIgnoresRead reads the source file of the collection in dir. A missing file has no entries.
+func sanityIgnoresRead(dir string) (*sanityIgnores, error) {
+	source := &sanityIgnores{}
+	path := filepath.Join(dir, SanityIgnoresFile)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return source, nil
+	}
+	if err := fixtureRead(path, source); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+// sanityIgnoreValidate checks that the path exists and the test and error code are known.
+// Unknown validate-modules codes are returned as warnings.
+func sanityIgnoreValidate(ignore sanityIgnore) (problems, warnings []string) {
+	entry := ignore.Line()
+	if _, err := os.Stat(ignore.Path); err != nil {
+		problems = append(problems, fmt.Sprintf("%s: path does not exist", entry))
+	}
+	test := ignore.Test
+	codes, ok := sanityTests[test]
+	if match := sanityTestPython.FindStringSubmatch(test); !ok && match != nil {
+		test = match[1]
+		codes, ok = sanityTests[test]
+	}
+	switch {
+	case !ok:
+		problems = append(problems, fmt.Sprintf("%s: unknown sanity test %q", entry, ignore.Test))
+	case ignore.Code == "skip" || codes.MatchString(ignore.Code):
+	case test == "validate-modules" && sanityCodeFormat.MatchString(ignore.Code):
+		warnings = append(warnings, fmt.Sprintf("%s: error code %q is not known for %s, check it with ansible-test",
+			entry, ignore.Code, ignore.Test))
+	default:
+		problems = append(problems, fmt.Sprintf("%s: unknown error code %q for %s", entry, ignore.Code, ignore.Test))
+	}
+	for _, version := range ignore.Versions {
+		if _, err := strconv.ParseFloat(version, 64); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: invalid version %q, expected e.g. \"2.15\"", entry, version))
+		}
+	}
+	return problems, warnings
+}
+
+// sanityVersions returns the ansible-core versions of AnsibleVersions, "devel" is the one after the latest stable.
+func sanityVersions() ([]string, error) {
+	versions := []string{}
+	for _, version := range strings.Fields(AnsibleVersions) {
+		release, err := ansibleRelease(version)
+		if err != nil {
+			return nil, fmt.Errorf("in AnsibleVersions: %w", err)
+		}
+		versions = append(versions, release)
+	}
+	return versions, nil
+}
+
+func sanityVersionMatch(versions []string, version string) bool {
+	for _, candidate := range versions {
+		if candidate == version {
+			return true
+		}
+	}
+	return false
+}
+
+// sanityIgnoresStale returns the entries ansible-test reported as unnecessary in the JUnit files of the last run,
+// testRunStart removes the ones of earlier runs.
+func sanityIgnoresStale(dir string) ([]string, error) {
+	cases, err := junitRead(dir, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	stale := []string{}
+	for _, c := range cases {
+		for _, row := range junitFailures(c) {
+			location, message := row[1], row[2]
+			if !strings.HasPrefix(location, "tests/sanity/ignore-") || !strings.Contains(message, "unnecessary") {
+				continue
+			}
+			file, number, _ := strings.Cut(location, ":")
+			line, err := sanityIgnoreLine(filepath.Join(dir, file), number)
+			if err != nil {
+				return nil, err
+			}
+			stale = append(stale, fmt.Sprintf("%s (%s)", line, location))
+		}
+	}
+	return stale, nil
+}
+
+// sanityIgnoreLine returns the line of an ignore file, without the comment.
+func sanityIgnoreLine(path, number string) (string, error) {
+	n, err := strconv.Atoi(number)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 1; scanner.Scan(); i++ {
+		if i == n {
+			line, _, _ := strings.Cut(scanner.Text(), " #")
+			return line, nil
+		}
+	}
+	return "", scanner.Err()
+}
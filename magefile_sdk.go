@@ -0,0 +1,298 @@
+//go:build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// SDKPackage is the Python package of the DSV SDK used by the lookup plugin.
+	SDKPackage = "python-dsv-sdk"
+
+	// SDKCompatibilityReport is the compatibility table written by `mage testSDK`, ready to publish in the docs.
+	SDKCompatibilityReport = ArtifactDir + "/sdk-compatibility.md"
+)
+
+// sdkRequirement finds the SDK version the plugin asks users to install.
+var sdkRequirement = regexp.MustCompile(regexp.QuoteMeta(SDKPackage) + `==([0-9][0-9A-Za-z.]*)`)
+
+// sdkArchive matches wheels and source archives of the SDK in a wheelhouse.
+var sdkArchive = regexp.MustCompile(`^python[-_]dsv[-_]sdk-([0-9][0-9A-Za-z.]*?)(-py|\.tar\.gz$|\.zip$)`)
+
+// sdkResult is the outcome of testing one SDK version.
+type sdkResult struct {
+	Version  string
+	Units    string
+	E2E      string
+	Duration time.Duration
+	Log      string
+	Err      error
+}
+
+// 🧩 TestSDK runs the unit and end-to-end tests against several python-dsv-sdk versions, each in its own virtual environment.
+// Versions are separated by commas or spaces, "all" tests every version published (or found in `SDK_WHEELHOUSE`).
+// Set `SDK_WHEELHOUSE` to a directory of wheels to install from it without network access.
+func TestSDK(versions string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("python-dsv-sdk compatibility")
+
+	wheelhouse := os.Getenv("SDK_WHEELHOUSE")
+	if wheelhouse != "" {
+		if _, err := os.Stat(wheelhouse); err != nil {
+			return fmt.Errorf("SDK_WHEELHOUSE: %w", err)
+		}
+	}
+
+	python, err := pythonSelect(os.Getenv("PYTHON"), AnsibleLatest)
+	if err != nil {
+		return err
+	}
+
+	list := strings.FieldsFunc(versions, func(r rune) bool { return r == ',' || r == ' ' })
+	if len(list) == 0 || (len(list) == 1 && list[0] == "all") {
+		list, err = sdkVersions(python, wheelhouse)
+		if err != nil {
+			return err
+		}
+	}
+
+	logDir := filepath.Join(ArtifactDir, "sdk")
+	if err := mkdir(logDir); err != nil {
+		return err
+	}
+	pterm.Info.Printfln("testing %s %s with Ansible %s and Python %s, logs in %q",
+		SDKPackage, strings.Join(list, ", "), AnsibleLatest, python.Minor(), logDir)
+
+	results := []sdkResult{}
+	for _, version := range list {
+		result := sdkRun(version, python, wheelhouse, logDir)
+		if result.Err != nil {
+			pterm.Error.Printfln("%s: %v", version, result.Err)
+		} else {
+			pterm.Success.Printfln("%s (took: %s)", version, result.Duration.Round(time.Second))
+		}
+		results = append(results, result)
+	}
+
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	tbl := pterm.TableData{[]string{SDKPackage, "Units", "E2E", "Duration", "Log"}}
+	for _, result := range results {
+		tbl = append(tbl, []string{
+			result.Version, result.Units, result.E2E, result.Duration.Round(time.Second).String(), result.Log,
+		})
+	}
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+
+	if err := writeFile(SDKCompatibilityReport, sdkReport(results, python)); err != nil {
+		return err
+	}
+	pterm.Success.Printfln("compatibility table written to %q", SDKCompatibilityReport)
+
+	return sdkCheckRequirement(results)
+}
+
+// sdkVersions lists the SDK versions in the wheelhouse or, without one, those published on PyPI.
+func sdkVersions(python pythonInterpreter, wheelhouse string) ([]string, error) {
+	seen := map[string]bool{}
+	versions := []string{}
+	if wheelhouse != "" {
+		entries, err := os.ReadDir(wheelhouse)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if match := sdkArchive.FindStringSubmatch(entry.Name()); match != nil && !seen[match[1]] {
+				seen[match[1]] = true
+				versions = append(versions, match[1])
+			}
+		}
+	} else {
+		// pip prints e.g. "Available versions: 1.0.1, 1.0.0, 0.0.1".
+		output, err := sh.Output(python.Path, "-m", "pip", "index", "versions", SDKPackage, "--disable-pip-version-check")
+		if err != nil {
+			return nil, fmt.Errorf("listing %s versions (set SDK_WHEELHOUSE when offline): %w", SDKPackage, err)
+		}
+		for _, line := range strings.Split(output, "\n") {
+			if available := strings.TrimPrefix(line, "Available versions:"); available != line {
+				for _, version := range strings.Split(available, ",") {
+					versions = append(versions, strings.TrimSpace(version))
+				}
+			}
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("no %s versions found", SDKPackage)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+	return versions, nil
+}
+
+// sdkRun creates the virtual environment of the SDK version if missing and runs the unit and end-to-end tests with it.
+func sdkRun(version string, python pythonInterpreter, wheelhouse, logDir string) (result sdkResult) {
+	const (
+		passed  = "✅"
+		failed  = "❌"
+		skipped = "➖"
+	)
+
+	now := time.Now()
+	result = sdkResult{Version: version, Units: skipped, E2E: skipped, Log: filepath.Join(logDir, version+".log")}
+	defer func() { result.Duration = time.Since(now) }()
+
+	log, err := os.Create(result.Log)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer log.Close()
+
+	venv := venvKeyed(AnsibleLatest, python) + "-sdk-" + version
+	if err := sdkInit(venv, python, version, wheelhouse); err != nil {
+		result.Err = err
+		return result
+	}
+
+	if err := fastRunIn(venv, "", nil, log); err != nil {
+		result.Units, result.Err = failed, fmt.Errorf("unit tests: %w", err)
+	} else {
+		result.Units = passed
+	}
+
+	scenarios, err := integrationSuite(venv)
+	if err != nil {
+		result.E2E = failed
+		if result.Err == nil {
+			result.Err = err
+		}
+		return result
+	}
+	failures := 0
+	for _, scenario := range scenarios {
+		if scenario.Err != nil {
+			failures++
+			fmt.Fprintf(log, "e2e %s: FAILED: %v\n", scenario.Name, scenario.Err)
+		} else {
+			fmt.Fprintf(log, "e2e %s: passed\n", scenario.Name)
+		}
+	}
+	if failures > 0 {
+		result.E2E = failed
+		if result.Err == nil {
+			result.Err = fmt.Errorf("%d of %d end-to-end scenarios failed", failures, len(scenarios))
+		}
+	} else {
+		result.E2E = passed
+	}
+	return result
+}
+
+// sdkInit creates the virtual environment with Ansible, the test tools and the SDK version,
+// reusing it when that version is installed already.
+func sdkInit(venv string, python pythonInterpreter, version, wheelhouse string) error {
+	runnable, env := venvCommandIn(venv, nil, "pip3")
+	if output, err := sh.OutputWith(env, runnable, "show", SDKPackage); err == nil &&
+		strings.Contains(output, "\nVersion: "+version+"\n") && venvBinExistsIn(venv, "ansible-playbook") {
+		return nil
+	}
+
+	ansible := fmt.Sprintf("https://github.com/ansible/ansible/archive/%s.tar.gz", AnsibleLatest)
+	if wheelhouse != "" {
+		release, err := ansibleRelease(AnsibleLatest)
+		if err != nil {
+			return err
+		}
+		ansible = fmt.Sprintf("ansible-core~=%s.0", release)
+	}
+
+	if err := venvCreate(venv, python.Path, false); err != nil {
+		return err
+	}
+	for _, name := range []string{"wheel", ansible, "pytest", "pytest-cov", "mock", SDKPackage + "==" + version} {
+		if err := sdkInstall(venv, wheelhouse, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sdkInstall installs the package into the virtual environment, only from the wheelhouse if one is given.
+func sdkInstall(venv, wheelhouse, name string) error {
+	if wheelhouse == "" {
+		return venvInstallIn(venv, name)
+	}
+	now := time.Now()
+	runnable, env := venvCommandIn(venv, nil, "pip3")
+	if err := sh.RunWith(env, runnable,
+		"install", name, "--no-index", "--find-links", wheelhouse, "--disable-pip-version-check",
+	); err != nil {
+		pterm.Error.Printfln("error installing name %q from %q: %s", name, wheelhouse, err)
+		return err
+	}
+	pterm.Success.Printfln(" - installed %q from %q (took: %s)", name, wheelhouse, time.Since(now))
+	return nil
+}
+
+// sdkReport renders the results as a Markdown table.
+func sdkReport(results []sdkResult, python pythonInterpreter) string {
+	report := &strings.Builder{}
+	fmt.Fprintf(report, "Tested with ansible-core %s and Python %s on %s.\n\n",
+		AnsibleLatest, python.Minor(), time.Now().UTC().Format("2006-01-02"))
+	fmt.Fprintf(report, "| %s | Unit tests | End-to-end tests |\n", SDKPackage)
+	fmt.Fprintln(report, "| --- | --- | --- |")
+	for _, result := range results {
+		fmt.Fprintf(report, "| %s | %s | %s |\n", result.Version, result.Units, result.E2E)
+	}
+	return report.String()
+}
+
+// sdkCheckRequirement compares the SDK version the plugin requires with the results. It warns when other
+// versions pass as well and fails when the required version does not.
+func sdkCheckRequirement(results []sdkResult) error {
+	source, err := os.ReadFile(PluginDocPathPattern)
+	if err != nil {
+		return err
+	}
+	match := sdkRequirement.FindSubmatch(source)
+	if match == nil {
+		pterm.Warning.Printfln("%s does not state a %s version", PluginDocPathPattern, SDKPackage)
+		return nil
+	}
+	required := string(match[1])
+
+	passing := []string{}
+	var requiredResult *sdkResult
+	for i, result := range results {
+		if result.Version == required {
+			requiredResult = &results[i]
+		}
+		if result.Err == nil {
+			passing = append(passing, result.Version)
+		}
+	}
+
+	switch {
+	case requiredResult == nil:
+		pterm.Warning.Printfln("%s requires %s==%s, which was not tested", PluginDocPathPattern, SDKPackage, required)
+	case requiredResult.Err != nil:
+		return fmt.Errorf("%s requires %s==%s, which fails: %w", PluginDocPathPattern, SDKPackage, required, requiredResult.Err)
+	}
+	if len(passing) > 0 && (len(passing) > 1 || passing[0] != required) {
+		pterm.Warning.Printfln("%s requires %s==%s, but the tests pass with %s",
+			PluginDocPathPattern, SDKPackage, required, strings.Join(passing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,297 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// DSVURLTemplate is the default API URL of a tenant, the same as the `url_template` default of the lookup plugin.
+	DSVURLTemplate = "https://{}.secretsvaultcloud.{}/v1"
+
+	// DSVTLD is the default top-level domain of a tenant.
+	DSVTLD = "com"
+)
+
+// 🌱 Seed creates or updates the secrets of a fixture file (YAML or JSON) in DSV, configured like the lookup plugin
+// with `DSV_TENANT`, `DSV_CLIENT_ID`, `DSV_CLIENT_SECRET` and optionally `DSV_TLD` and `DSV_URL_TEMPLATE`.
+// Point `DSV_URL_TEMPLATE` at `mage dsv:mock` to seed the stand-in, set `DSV_SEED_DRY_RUN=true` to only show the changes.
+func (Dsv) Seed(file string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Seed DSV secrets")
+	return dsvSeed(file, false)
+}
+
+// 🧹 Unseed deletes the secrets of a fixture file from DSV, configured like `mage dsv:seed`.
+func (Dsv) Unseed(file string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Unseed DSV secrets")
+	return dsvSeed(file, true)
+}
+
+// dsvSeed compares the secrets of the fixture with DSV and applies the difference, deleting them if remove is set.
+func dsvSeed(file string, remove bool) error {
+	fixture, err := dsvFixtureRead(file)
+	if err != nil {
+		return err
+	}
+	client, err := dsvClientFromEnv()
+	if err != nil {
+		return err
+	}
+	dryRun := os.Getenv("DSV_SEED_DRY_RUN") == "true"
+	pterm.Info.Printfln("using %s", client.URL)
+
+	paths := make([]string, 0, len(fixture.Secrets))
+	for path := range fixture.Secrets {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	tbl := pterm.TableData{[]string{"Path", "Action", "Changes"}}
+	changed := 0
+	for _, key := range paths {
+		seed, path := fixture.Secrets[key], dsvPath(key)
+		current, found, err := client.Secret(path)
+		if err != nil {
+			return err
+		}
+
+		action, changes, method := "unchanged", []string{}, ""
+		switch {
+		case remove && found:
+			action, method = "delete", http.MethodDelete
+		case remove:
+			action = "absent"
+		case !found:
+			action, method = "create", http.MethodPost
+			changes = dsvDiff("", map[string]interface{}{}, dsvSeedFields(seed, nil))
+		default:
+			desired := dsvSeedFields(seed, nil)
+			changes = dsvDiff("", dsvSeedFields(current, desired), desired)
+			if len(changes) > 0 {
+				action, method = "update", http.MethodPut
+			}
+		}
+		tbl = append(tbl, []string{path, action, strings.Join(changes, "\n")})
+
+		if method == "" {
+			continue
+		}
+		changed++
+		if dryRun {
+			continue
+		}
+		var body interface{}
+		if method != http.MethodDelete {
+			body = dsvSeedFields(seed, nil)
+		}
+		if _, err := client.Do(method, "secrets/"+path, body, nil); err != nil {
+			return fmt.Errorf("%s secret %q: %w", action, path, err)
+		}
+	}
+
+	primary := pterm.NewStyle(pterm.FgLightWhite, pterm.BgGray, pterm.Bold)
+	if err := pterm.DefaultTable.WithHasHeader().WithBoxed().WithHeaderStyle(primary).WithData(tbl).Render(); err != nil {
+		pterm.Error.Printf("pterm.TablePrinter: Render() failed. Continuing...\n%v", err)
+	}
+
+	switch {
+	case changed == 0:
+		pterm.Success.Println("nothing to change")
+	case dryRun:
+		pterm.Warning.Printfln("dry run, %d secrets not changed", changed)
+	default:
+		pterm.Success.Printfln("changed %d secrets", changed)
+	}
+	return nil
+}
+
+// dsvSeedFields returns the fields of a secret that are seeded. If like is set, only the fields present in it
+// are returned, so fields missing in the fixture don't show up as changes.
+func dsvSeedFields(secret, like map[string]interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	for _, key := range []string{"data", "description", "attributes"} {
+		if _, ok := like[key]; like != nil && !ok {
+			continue
+		}
+		if value, ok := secret[key]; ok {
+			fields[key] = value
+		}
+	}
+	return fields
+}
+
+// dsvDiff lists the keys that differ between current and desired, e.g. "+ data.password".
+// Values are left out, they may be real secrets.
+func dsvDiff(prefix string, current, desired interface{}) []string {
+	currentMap, currentOK := current.(map[string]interface{})
+	desiredMap, desiredOK := desired.(map[string]interface{})
+	if !currentOK || !desiredOK {
+		if reflect.DeepEqual(current, desired) {
+			return nil
+		}
+		return []string{"~ " + prefix}
+	}
+
+	keys := []string{}
+	for key := range currentMap {
+		keys = append(keys, key)
+	}
+	for key := range desiredMap {
+		if _, ok := currentMap[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []string{}
+	for _, key := range keys {
+		name := key
+		if prefix != "" {
+			name = prefix + "." + key
+		}
+		currentValue, inCurrent := currentMap[key]
+		desiredValue, inDesired := desiredMap[key]
+		switch {
+		case !inCurrent:
+			changes = append(changes, "+ "+name)
+		case !inDesired:
+			changes = append(changes, "- "+name)
+		default:
+			changes = append(changes, dsvDiff(name, currentValue, desiredValue)...)
+		}
+	}
+	return changes
+}
+
+// dsvClient calls the DSV REST API with a token from the client credentials flow, like python-dsv-sdk.
+type dsvClient struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	Client       *http.Client
+
+	token string
+}
+
+// dsvClientFromEnv configures the client from the environment variables of the lookup plugin options.
+func dsvClientFromEnv() (*dsvClient, error) {
+	tenant := os.Getenv("DSV_TENANT")
+	client := &dsvClient{
+		ClientID:     os.Getenv("DSV_CLIENT_ID"),
+		ClientSecret: os.Getenv("DSV_CLIENT_SECRET"),
+		Client:       &http.Client{Timeout: 30 * time.Second},
+	}
+	if tenant == "" || client.ClientID == "" || client.ClientSecret == "" {
+		return nil, fmt.Errorf("DSV_TENANT, DSV_CLIENT_ID and DSV_CLIENT_SECRET are required")
+	}
+
+	tld := os.Getenv("DSV_TLD")
+	if tld == "" {
+		tld = DSVTLD
+	}
+	template := os.Getenv("DSV_URL_TEMPLATE")
+	if template == "" {
+		template = DSVURLTemplate
+	}
+	// Same as Python's str.format with positional placeholders.
+	client.URL = strings.TrimSuffix(strings.Replace(strings.Replace(template, "{}", tenant, 1), "{}", tld, 1), "/")
+	return client, nil
+}
+
+// Secret returns the secret at path, found is false if DSV answers 404.
+func (c *dsvClient) Secret(path string) (secret map[string]interface{}, found bool, err error) {
+	status, err := c.Do(http.MethodGet, "secrets/"+path, nil, &secret)
+	if status == http.StatusNotFound {
+		return nil, false, nil
+	}
+	return secret, err == nil, err
+}
+
+// Do sends body as JSON to the API path and decodes the response into v, requesting a token first if needed.
+func (c *dsvClient) Do(method, path string, body, v interface{}) (int, error) {
+	if c.token == "" {
+		response := struct {
+			AccessToken string `json:"accessToken"`
+		}{}
+		//nolint:tagliatelle // DSV API uses snake_case keys for the token request.
+		request := struct {
+			GrantType    string `json:"grant_type"`
+			ClientID     string `json:"client_id"`
+			ClientSecret string `json:"client_secret"`
+		}{"client_credentials", c.ClientID, c.ClientSecret}
+		if _, err := c.request(http.MethodPost, "token", request, &response); err != nil {
+			return 0, fmt.Errorf("requesting access token: %w", err)
+		}
+		c.token = response.AccessToken
+	}
+	return c.request(method, path, body, v)
+}
+
+func (c *dsvClient) request(method, path string, body, v interface{}) (int, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := c.URL + "/" + (&url.URL{Path: path}).EscapedPath()
+	request, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Accept", "application/json")
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	pterm.Debug.Printfln("%s %s", method, target)
+	response, err := c.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return response.StatusCode, err
+	}
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		message := struct {
+			Message string `json:"message"`
+		}{}
+		if json.Unmarshal(data, &message) != nil || message.Message == "" {
+			message.Message = strings.TrimSpace(string(data))
+		}
+		return response.StatusCode, fmt.Errorf("%s %s: %s: %s", method, path, response.Status, message.Message)
+	}
+	if v != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, v); err != nil {
+			return response.StatusCode, fmt.Errorf("%s %s: %w", method, path, err)
+		}
+	}
+	return response.StatusCode, nil
+}
@@ -0,0 +1,246 @@
+//go:build mage
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/sh"
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// IntegrationTargetsDir contains the targets of `ansible-test integration`.
+	IntegrationTargetsDir = "tests/integration/targets"
+
+	// IntegrationConfigFile passes the DSV stand-in to the targets as variables, written by `mage testIntegration`.
+	IntegrationConfigFile = "tests/integration/integration_config.yml"
+)
+
+// integrationTargetName is what ansible-test accepts as target name.
+var integrationTargetName = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// integrationConfig is written to IntegrationConfigFile, secrets of the stand-in only.
+const integrationConfig = `---
+# Written by ` + "`mage testIntegration`" + ` for the DSV stand-in, removed after the run.
+dsv_tenant: mock
+dsv_client_id: %q
+dsv_client_secret: %q
+dsv_url_template: %q
+`
+
+const integrationTargetAliases = `# Lookup plugins run on the controller, see
+# https://docs.ansible.com/ansible/latest/dev_guide/testing/sanity/integration-aliases.html
+context/controller
+shippable/posix/group1
+`
+
+const integrationTargetTasks = `---
+# dsv_tenant, dsv_client_id, dsv_client_secret and dsv_url_template point at the DSV stand-in
+# serving the secrets in fixtures/, see tests/integration/integration_config.yml.
+- name: Look up the password of the secret
+  ansible.builtin.set_fact:
+    password: >-
+      {{ lookup('delinea.core.dsv', '/%[1]s/secret', data_key='password',
+                tenant=dsv_tenant, client_id=dsv_client_id, client_secret=dsv_client_secret,
+                url_template=dsv_url_template) }}
+
+- name: Check the password
+  ansible.builtin.assert:
+    that:
+      - password == '%[1]s-password'
+`
+
+const integrationTargetFixture = `---
+# Secrets served by the DSV stand-in during ` + "`mage testIntegration`" + `, in addition to the ones
+# in tests/e2e/fixtures/. Secret paths must be unique across all fixtures.
+secrets:
+  %[1]s/secret:
+    description: secret used by the %[1]s integration target
+    data:
+      password: %[1]s-password
+`
+
+// 🧪 TestIntegration runs the ansible-test integration targets against a local DSV stand-in, in containers when
+// available (see `TEST_MODE`). Set `TEST_INTEGRATION_TARGETS` to the targets to run, all by default.
+func TestIntegration() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("ansible-test integration")
+
+	if !venvBinExists("ansible-test") {
+		pterm.Error.Println("run `mage init` first")
+		return nil
+	}
+	target, err := testTargetDefault()
+	if err != nil {
+		return err
+	}
+	if target.Mode == TestModeLocal {
+		if err := integrationInstall(); err != nil {
+			return err
+		}
+	}
+
+	fixture, err := integrationTargetsFixture()
+	if err != nil {
+		return err
+	}
+	host, listen, err := integrationTargetsHost(target.Mode)
+	if err != nil {
+		return err
+	}
+	server := newDSVServer(fixture)
+	if err := server.Start(listen); err != nil {
+		return fmt.Errorf("dsv stand-in cannot listen on %s, set INTEGRATION_DSV_HOST to a local address the containers reach: %w",
+			listen, err)
+	}
+	defer server.Close()
+
+	// The stand-in listens on the address the targets reach the host with, see integrationTargetsHost.
+	serverURL, err := url.Parse(server.URLTemplate())
+	if err != nil {
+		return err
+	}
+	serverURL.Host = net.JoinHostPort(host, serverURL.Port())
+	config := fmt.Sprintf(integrationConfig,
+		fixture.Credentials.ClientID, fixture.Credentials.ClientSecret, serverURL.String())
+	if err := writeFile(filepath.Join(target.Dir, IntegrationConfigFile), config); err != nil {
+		return err
+	}
+	defer os.Remove(filepath.Join(target.Dir, IntegrationConfigFile))
+	pterm.Info.Printfln("targets reach the dsv stand-in at %s", serverURL)
+
+	args := append([]string{"integration"}, target.modeArgs()...)
+	args = append(args, "--color", "yes")
+	if target.Mode != TestModeLocal {
+		// Installs tests/integration/requirements.txt, e.g. the SDK, where ansible-test runs.
+		args = append(args, "--requirements")
+	}
+	if target.Selection != nil {
+		args = append(args, target.Selection.changedArgs()...)
+	}
+	args = append(args, strings.FieldsFunc(os.Getenv("TEST_INTEGRATION_TARGETS"), func(r rune) bool {
+		return r == ',' || r == ' '
+	})...)
+
+	if err := testRunStart(target.Dir); err != nil {
+		return err
+	}
+	now := time.Now()
+	err = target.run(true, "ansible-test", args...)
+	testReport(target, now, err)
+	if err != nil {
+		return err
+	}
+	pterm.Success.Printfln("integration tests (took: %s)", time.Since(now))
+	return nil
+}
+
+// 🏗️ NewIntegrationTarget creates an ansible-test integration target with aliases, tasks and a fixture
+// for the DSV stand-in in `tests/integration/targets/<name>`.
+func NewIntegrationTarget(name string) error {
+	magetoolsutils.CheckPtermDebug()
+
+	if !integrationTargetName.MatchString(name) {
+		return fmt.Errorf("invalid target name %q, use lowercase letters, digits and underscores", name)
+	}
+	dir := filepath.Join(IntegrationTargetsDir, name)
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("target %q already exists", dir)
+	}
+
+	files := [][2]string{
+		{"aliases", integrationTargetAliases},
+		{filepath.Join("tasks", "main.yml"), fmt.Sprintf(integrationTargetTasks, name)},
+		{filepath.Join("fixtures", "secrets.yml"), fmt.Sprintf(integrationTargetFixture, name)},
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file[0])
+		if err := mkdir(filepath.Dir(path)); err != nil {
+			return err
+		}
+		const permBits = 0o644
+		if err := os.WriteFile(path, []byte(file[1]), permBits); err != nil {
+			return err
+		}
+		pterm.Success.Printfln("created %q", path)
+	}
+	pterm.Info.Printfln("run it with: TEST_INTEGRATION_TARGETS=%s mage testIntegration", name)
+	return nil
+}
+
+// integrationTargetsFixture merges the end-to-end fixtures with the fixtures of every integration target.
+// Faults are left out, they would apply to every target.
+func integrationTargetsFixture() (*dsvFixture, error) {
+	fixture, err := dsvFixtureReadDir(filepath.Join(E2EDir, "fixtures"))
+	if err != nil {
+		return nil, err
+	}
+	fixture.Faults = nil
+
+	dirs, err := filepath.Glob(filepath.Join(IntegrationTargetsDir, "*", "fixtures"))
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		files, err := dsvFixtureFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			target, err := dsvFixtureRead(file)
+			if err != nil {
+				return nil, err
+			}
+			for path, secret := range target.Secrets {
+				for existing := range fixture.Secrets {
+					if dsvPath(existing) == dsvPath(path) {
+						return nil, fmt.Errorf("secret %q of %q is defined in another fixture", path, file)
+					}
+				}
+				fixture.Secrets[path] = secret
+			}
+		}
+	}
+	return fixture, nil
+}
+
+// integrationTargetsHost returns the address the targets reach the host with in the execution mode,
+// and the address for the stand-in to listen on, never all interfaces as the fixtures hold credentials.
+// Set `INTEGRATION_DSV_HOST` to a local address of the host the containers can reach to override both.
+func integrationTargetsHost(mode string) (string, string, error) {
+	host := os.Getenv("INTEGRATION_DSV_HOST")
+	switch {
+	case host != "":
+		return host, net.JoinHostPort(host, "0"), nil
+
+	case mode == TestModeDocker:
+		// Containers on the default bridge network reach the host through its gateway.
+		gateway, err := sh.Output("docker", "network", "inspect", "bridge", "--format", "{{(index .IPAM.Config 0).Gateway}}")
+		if err != nil || net.ParseIP(gateway) == nil {
+			return "", "", errors.New("finding the docker bridge gateway failed, set INTEGRATION_DSV_HOST to the host address")
+		}
+		return gateway, net.JoinHostPort(gateway, "0"), nil
+
+	case mode == TestModePodman:
+		// Like with docker, containers on the default network reach the host through its gateway.
+		gateway, err := sh.Output("podman", "network", "inspect", "podman", "--format", "{{(index .Subnets 0).Gateway}}")
+		if err != nil || net.ParseIP(gateway) == nil {
+			return "", "", errors.New("finding the podman network gateway failed, set INTEGRATION_DSV_HOST to the host address")
+		}
+		return gateway, net.JoinHostPort(gateway, "0"), nil
+
+	default:
+		return "127.0.0.1", "127.0.0.1:0", nil
+	}
+}
@@ -0,0 +1,241 @@
+//go:build mage
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pterm/pterm"
+	"github.com/sheldonhull/magetools/pkg/magetoolsutils"
+)
+
+const (
+	// WatchDirs are the directories `mage watch` monitors for changes.
+	WatchDirs = "plugins tests meta"
+
+	// WatchPoll is how often `mage watch` looks for changes.
+	WatchPoll = 500 * time.Millisecond
+
+	// WatchDebounce is how long files have to stay unchanged before the tests run.
+	WatchDebounce = time.Second
+)
+
+// watchDocCheck loads the DOCUMENTATION, EXAMPLES and RETURN strings of the plugins given as arguments with PyYAML,
+// like ansible-doc does, without importing the plugins.
+const watchDocCheck = `
+import ast, sys, yaml
+failed = False
+for path in sys.argv[1:]:
+    try:
+        tree = ast.parse(open(path).read(), path)
+    except SyntaxError as error:
+        print("%s:%s: %s" % (path, error.lineno, error.msg))
+        failed = True
+        continue
+    for node in tree.body:
+        if not isinstance(node, ast.Assign) or not isinstance(node.value, ast.Constant):
+            continue
+        for target in node.targets:
+            if isinstance(target, ast.Name) and target.id in ("DOCUMENTATION", "EXAMPLES", "RETURN"):
+                try:
+                    yaml.safe_load(node.value.value)
+                except yaml.YAMLError as error:
+                    mark = getattr(error, "problem_mark", None)
+                    line = node.value.lineno + (mark.line if mark else 0)
+                    print("%s:%d: %s: %s" % (path, line, target.id, str(error).splitlines()[0]))
+                    failed = True
+sys.exit(1 if failed else 0)
+`
+
+// 👀 Watch reruns the unit tests affected by changes in `plugins/`, `tests/` and `meta/` until interrupted,
+// with pytest when available (see TestFast), and checks the documentation of changed plugins.
+func Watch() error {
+	magetoolsutils.CheckPtermDebug()
+
+	pterm.DefaultHeader.Println("Watch")
+
+	if !venvExists() {
+		pterm.Error.Println("run `mage init` first")
+		return nil
+	}
+	fast := fastInstall() == nil
+	if !fast {
+		pterm.Warning.Println("pytest not available, running ansible-test units instead")
+	}
+
+	stamps, err := watchStamps()
+	if err != nil {
+		return err
+	}
+	pterm.Info.Printfln("watching %s, press Ctrl+C to stop", strings.Join(strings.Fields(WatchDirs), ", "))
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	ticker := time.NewTicker(WatchPoll)
+	defer ticker.Stop()
+
+	pending := map[string]bool{}
+	lastChange := time.Time{}
+	for {
+		select {
+		case <-interrupt:
+			pterm.Info.Println("watch stopped")
+			return nil
+
+		case <-ticker.C:
+			current, err := watchStamps()
+			if err != nil {
+				pterm.Warning.Printfln("scanning files: %v", err)
+				continue
+			}
+			for path, stamp := range current {
+				if stamps[path] != stamp {
+					pending[path] = true
+					lastChange = time.Now()
+				}
+			}
+			for path := range stamps {
+				if _, ok := current[path]; !ok {
+					pending[path] = true
+					lastChange = time.Now()
+				}
+			}
+			stamps = current
+
+			if len(pending) == 0 || time.Since(lastChange) < WatchDebounce {
+				continue
+			}
+			changed := make([]string, 0, len(pending))
+			for path := range pending {
+				changed = append(changed, path)
+			}
+			sort.Strings(changed)
+			pending = map[string]bool{}
+
+			watchRun(changed, fast)
+		}
+	}
+}
+
+// watchRun tests the changed files and prints a status line. Failures and panics never stop the watch.
+func watchRun(changed []string, fast bool) {
+	now := time.Now()
+	defer func() {
+		if r := recover(); r != nil {
+			pterm.Error.Printfln("%s watch run crashed: %v", now.Format("15:04:05"), r)
+		}
+	}()
+
+	allUnits, err := testUnitFiles()
+	if err != nil {
+		pterm.Error.Printfln("listing unit tests: %v", err)
+		return
+	}
+	units := map[string]bool{}
+	plugins := []string{}
+	for _, file := range changed {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		selected, _, _ := testSelect(file, allUnits)
+		for _, unit := range selected {
+			units[unit] = true
+		}
+		if strings.HasPrefix(file, "plugins/") && filepath.Ext(file) == ".py" {
+			plugins = append(plugins, file)
+		}
+	}
+	selection := make([]string, 0, len(units))
+	for unit := range units {
+		selection = append(selection, unit)
+	}
+	sort.Strings(selection)
+
+	status := []string{}
+	failed := false
+	output := &bytes.Buffer{}
+
+	if len(plugins) > 0 {
+		docs := &bytes.Buffer{}
+		runnable, env := venvCommand(nil, "python3")
+		target := testTarget{Venv: venvPath(), Dir: ".", Output: docs}
+		if err := target.runWith(true, env, runnable, append([]string{"-c", watchDocCheck}, plugins...)...); err != nil {
+			failed = true
+			status = append(status, "docs ❌")
+			output.Write(docs.Bytes())
+		} else {
+			status = append(status, "docs ✅")
+		}
+	}
+
+	if len(selection) > 0 {
+		started := time.Now()
+		if err := watchUnits(selection, fast, output); err != nil {
+			failed = true
+			status = append(status, fmt.Sprintf("%d unit test files ❌ (%s)", len(selection), watchSince(started)))
+		} else {
+			status = append(status, fmt.Sprintf("%d unit test files ✅ (%s)", len(selection), watchSince(started)))
+		}
+	}
+
+	if len(status) == 0 {
+		status = append(status, "nothing to test")
+	}
+	line := fmt.Sprintf("%s %s · %s", now.Format("15:04:05"), strings.Join(changed, ", "), strings.Join(status, " · "))
+	if failed {
+		pterm.Println(integrationTail(strings.TrimSpace(output.String())))
+		pterm.Error.Println(line)
+		return
+	}
+	pterm.Success.Println(line)
+}
+
+// watchUnits runs the unit test files with pytest, or with ansible-test if pytest is not available.
+func watchUnits(selection []string, fast bool, output *bytes.Buffer) error {
+	if fast {
+		return fastRun("", selection, output)
+	}
+	target, err := testTargetDefault()
+	if err != nil {
+		return err
+	}
+	target.Output = output
+	args := append([]string{"units"}, target.modeArgs()...)
+	return target.run(true, "ansible-test", append(append(args, "--color", "no"), selection...)...)
+}
+
+// watchStamps returns the size and modification time of every file in the watched directories.
+func watchStamps() (map[string]string, error) {
+	stamps := map[string]string{}
+	for _, dir := range strings.Fields(WatchDirs) {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			switch {
+			case os.IsNotExist(err):
+				return nil
+			case err != nil:
+				return err
+			case info.IsDir() && (info.Name() == "__pycache__" || path == filepath.Join("tests", "output")):
+				return filepath.SkipDir
+			case info.IsDir() || strings.HasSuffix(path, ".pyc"):
+				return nil
+			}
+			stamps[filepath.ToSlash(path)] = fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return stamps, nil
+}
+
+func watchSince(t time.Time) string {
+	return time.Since(t).Round(100 * time.Millisecond).String()
+}
@@ -13,7 +13,7 @@ try:
 except ImportError:
     from mock import patch
 
-from ansible.errors import AnsibleOptionsError
+from ansible.errors import AnsibleError, AnsibleOptionsError
 from ansible.plugins.loader import lookup_loader
 from ansible_collections.delinea.core.plugins.lookup import dsv
 
@@ -125,3 +125,86 @@ class TestLookupModule(TestCase):
             )
 
         assert str(exc.exception) == "Invalid secret path: :"
+
+    @patch("ansible_collections.delinea.core.plugins.lookup.dsv.SecretsVault")
+    def test_client(self, mock_vault):
+        client = dsv.LookupModule.Client({"tenant": "ten"})
+
+        mock_vault.assert_called_once_with(tenant="ten")
+        assert client == mock_vault.return_value
+
+    @patch("ansible_collections.delinea.core.plugins.lookup.dsv.SecretsVault")
+    def test_client_unsupported_sdk(self, mock_vault):
+        mock_vault.side_effect = TypeError("unexpected keyword argument 'tld'")
+
+        with self.assertRaises(AnsibleError) as exc:
+            dsv.LookupModule.Client({"tenant": "ten", "tld": "com"})
+
+        expected = "python-dsv-sdk==0.0.1 must be installed to use this plugin"
+        assert str(exc.exception) == expected
+
+    def test_run_sdk_missing(self):
+        dsv.sdk_is_missing = True
+
+        with self.assertRaises(AnsibleError) as exc:
+            self.lookup.run(
+                ["secret/path"],
+                [],
+                **{"tenant": "ten", "client_id": "cid", "client_secret": "csecret"}
+            )
+
+        expected = "python-dsv-sdk==0.0.1 must be installed to use this plugin"
+        assert str(exc.exception) == expected
+
+    @patch("ansible_collections.delinea.core.plugins.lookup.dsv.LookupModule.Client")
+    def test_run_vault_error(self, mock_client):
+        instance = mock_client.return_value
+        instance.get_secret_json.side_effect = dsv.SecretsVaultError("access denied")
+
+        with self.assertRaises(AnsibleError) as exc:
+            self.lookup.run(
+                ["secret/path"],
+                [],
+                **{"tenant": "ten", "client_id": "cid", "client_secret": "csecret"}
+            )
+
+        assert str(exc.exception) == "DSV lookup failure: access denied"
+
+    @patch("ansible_collections.delinea.core.plugins.lookup.dsv.LookupModule.Client")
+    def test_run_use_data_key_vault_error(self, mock_client):
+        instance = mock_client.return_value
+        instance.get_secret.side_effect = dsv.SecretsVaultError("access denied")
+
+        with self.assertRaises(AnsibleError) as exc:
+            self.lookup.run(
+                ["secret/path"],
+                [],
+                **{
+                    "data_key": "my-key",
+                    "tenant": "ten",
+                    "client_id": "cid",
+                    "client_secret": "csecret",
+                }
+            )
+
+        assert str(exc.exception) == "DSV lookup failure: access denied"
+
+    @patch("ansible_collections.delinea.core.plugins.lookup.dsv.LookupModule.Client")
+    def test_run_use_data_key_missing(self, mock_client):
+        instance = mock_client.return_value
+        instance.get_secret.return_value = {"data": {"other-key": "my-val"}}
+
+        with self.assertRaises(AnsibleOptionsError) as exc:
+            self.lookup.run(
+                ["secret/path"],
+                [],
+                **{
+                    "data_key": "my-key",
+                    "tenant": "ten",
+                    "client_id": "cid",
+                    "client_secret": "csecret",
+                }
+            )
+
+        expected = "DSV lookup failure: cannot find data key in secret data"
+        assert str(exc.exception) == expected